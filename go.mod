@@ -1,4 +1,4 @@
-module schema
+module github.com/gigagrug/schema
 
 go 1.26.0
 
@@ -12,6 +12,7 @@ require (
 	github.com/tliron/commonlog v0.2.21
 	github.com/tliron/glsp v0.2.2
 	github.com/tursodatabase/libsql-client-go v0.0.0-20251219100830-236aa1ff8acc
+	golang.org/x/term v0.42.0
 	modernc.org/sqlite v1.48.2
 	turso.tech/database/tursogo v0.5.3
 	vitess.io/vitess v0.23.3
@@ -64,7 +65,6 @@ require (
 	golang.org/x/net v0.53.0 // indirect
 	golang.org/x/sync v0.20.0 // indirect
 	golang.org/x/sys v0.43.0 // indirect
-	golang.org/x/term v0.42.0 // indirect
 	golang.org/x/text v0.36.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20260406210006-6f92a3bedf2d // indirect
 	google.golang.org/grpc v1.80.0 // indirect
@@ -78,7 +78,7 @@ func refreshSchemaCache() {
 		return
 	}
 
-	dbSchema, err := InspectSchema(context.Background(), lspDbConn, lspActiveDbType)
+	dbSchema, err := migrateConfig.InspectSchema(context.Background(), lspDbConn, lspActiveDbType)
 	if err != nil {
 		lspLog.Errorf("LSP failed to inspect schema on refresh: %v", err)
 		return
@@ -5,18 +5,20 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"charm.land/bubbles/v2/help"
 	"charm.land/bubbles/v2/key"
@@ -27,6 +29,7 @@ import (
 	"charm.land/bubbles/v2/viewport"
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
+	"github.com/gigagrug/schema/migrate"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
@@ -35,6 +38,7 @@ import (
 	protocol "github.com/tliron/glsp/protocol_3_16"
 	"github.com/tliron/glsp/server"
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
+	"golang.org/x/term"
 	_ "modernc.org/sqlite"
 	turso "turso.tech/database/tursogo"
 )
@@ -46,6 +50,12 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	args, err := parseOutputFlag(os.Args[1:])
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	os.Args = append(os.Args[:1], args...)
+
 	if len(os.Args) < 2 {
 		fmt.Print(`
  ____       _                          
@@ -69,9 +79,22 @@ func main() {
 	case "migrate":
 		runMigrate(ctx, os.Args[2:])
 	case "studio":
+		if jsonOutput {
+			fatalf("Error: studio is interactive and has no JSON output")
+		}
 		runStudio(os.Args[2:])
 	case "rollback":
 		runRollback(ctx, os.Args[2:])
+	case "status":
+		runStatus(ctx, os.Args[2:])
+	case "check":
+		runCheck(ctx, os.Args[2:])
+	case "redo":
+		runRedo(ctx, os.Args[2:])
+	case "baseline":
+		runBaseline(ctx, os.Args[2:])
+	case "squash":
+		runSquash(ctx, os.Args[2:])
 	case "remove", "rm":
 		runRemove(ctx, os.Args[2:])
 	case "pull":
@@ -81,13 +104,18 @@ func main() {
 	case "config":
 		runConfig(os.Args[2:])
 	case "lsp":
+		if jsonOutput {
+			fatalf("Error: lsp speaks the language server protocol and has no JSON output")
+		}
 		runLSP(os.Args[2:])
 	case "generate":
 		runGenerate(ctx, os.Args[2:])
 	default:
-		fmt.Printf("Unknown command: %s\nExpected Subcommands: studio, migrate, create, rollback, init, pull, sql, lsp, generate, help, version, config\n", os.Args[1])
-		os.Exit(0)
+		fmt.Printf("Unknown command: %s\nExpected Subcommands: studio, migrate, create, rollback, redo, status, check, baseline, squash, init, pull, sql, lsp, generate, help, version, config\n", os.Args[1])
+		writeResult(os.Args[1], exitUsage, map[string]any{"code": "unknown_command", "message": "Unknown command: " + os.Args[1]})
+		os.Exit(exitUsage)
 	}
+	writeResult(os.Args[1], 0, nil)
 }
 
 func printHelp() {
@@ -99,6 +127,11 @@ func printHelp() {
 	fmt.Println("  migrate      Run pending migrations")
 	fmt.Println("  create       Create a new migration file")
 	fmt.Println("  rollback     Rollback the last migration")
+	fmt.Println("  redo         Rollback and reapply the last migration")
+	fmt.Println("  status       Show applied and pending migrations")
+	fmt.Println("  check        Report drift between the database, migrations and db.schema")
+	fmt.Println("  baseline     Mark migrations as applied on an existing database")
+	fmt.Println("  squash       Replace old migrations with one snapshot migration")
 	fmt.Println("  remove       Remove a migration file")
 	fmt.Println("  pull         Update schema.db file from database")
 	fmt.Println("  sql          Run a raw SQL query or file")
@@ -116,35 +149,37 @@ func printHelp() {
 
 func checkVersion(ctx context.Context) {
 	fmt.Println("Version:", version)
+	setResult("version", version)
 
 	url := "https://api.github.com/repos/gigagrug/schema/releases/latest"
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		log.Fatalf("Error creating request: %v\n", err)
+		fatalf("Error creating request: %v\n", err)
 	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Fatalf("Error fetching release data from GitHub: %v\n", err)
+		fatalf("Error fetching release data from GitHub: %v\n", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("Error reading GitHub response body: %v\n", err)
+		fatalf("Error reading GitHub response body: %v\n", err)
 	}
 
 	var result map[string]any
 	err = json.Unmarshal(body, &result)
 	if err != nil {
-		log.Fatalf("Error unmarshalling JSON: %v\n", err)
+		fatalf("Error unmarshalling JSON: %v\n", err)
 	}
 
 	latestVersion, ok := result["tag_name"].(string)
 	if !ok {
-		log.Fatalf("Could not find 'tag_name' or it was not a string in the GitHub release data\n")
+		fatalf("Could not find 'tag_name' or it was not a string in the GitHub release data\n")
 	}
 
+	setResult("latest", latestVersion)
 	if version != latestVersion {
 		fmt.Printf("Outdated! Latest version: %s\n", latestVersion)
 		fmt.Printf("curl -sSfL https://raw.githubusercontent.com/gigagrug/schema/main/install.sh | sh -s\n")
@@ -169,18 +204,18 @@ func runInit(args []string) {
 	if _, err := os.Stat(schemaPath); os.IsNotExist(err) {
 		err := os.Mkdir(filepath.Join(*rdir), 0700)
 		if err != nil {
-			log.Fatalf("Error creating schema/migrations directory: %v\n", err)
+			fatalf("Error creating schema/migrations directory: %v\n", err)
 		}
 		schemaFile, err := os.Create(schemaPath)
 		if err != nil {
-			log.Fatalf("Error creating file: %v\n", err)
+			fatalf("Error creating file: %v\n", err)
 		}
 		defer schemaFile.Close()
 
 		fileContent := fmt.Sprintf("db = \"%s\"\nurl = env(\"%s_DB_URL\")", *db, strings.ToUpper(*rdir))
 		_, err = schemaFile.WriteString(fileContent)
 		if err != nil {
-			log.Fatalf("Error writing to file: %v\n", err)
+			fatalf("Error writing to file: %v\n", err)
 		}
 	}
 
@@ -190,26 +225,26 @@ func runInit(args []string) {
 	if _, err := os.Stat(envPath); os.IsNotExist(err) {
 		envFile, err := os.Create(envPath)
 		if err != nil {
-			log.Fatalf("Error creating .env file: %v\n", err)
+			fatalf("Error creating .env file: %v\n", err)
 		}
 		defer envFile.Close()
 
 		schemaContent := fmt.Sprintf(`%s_DB_URL="%s"`, strings.ToUpper(*rdir), safeUrl)
 		_, err = envFile.WriteString(schemaContent)
 		if err != nil {
-			log.Fatalf("Error writing to .env file: %v\n", err)
+			fatalf("Error writing to .env file: %v\n", err)
 		}
 	} else {
 		envFile, err := os.OpenFile(envPath, os.O_APPEND|os.O_WRONLY, 0600)
 		if err != nil {
-			log.Fatalf("Error opening .env file: %v\n", err)
+			fatalf("Error opening .env file: %v\n", err)
 		}
 		defer envFile.Close()
 
 		schemaContent := fmt.Sprintf("\n%s_DB_URL=\"%s\"", strings.ToUpper(*rdir), safeUrl)
 		_, err = envFile.WriteString(schemaContent)
 		if err != nil {
-			log.Fatalf("Error appending to .env file: %v\n", err)
+			fatalf("Error appending to .env file: %v\n", err)
 		}
 	}
 	fmt.Println("Schema successfully initialized")
@@ -223,6 +258,8 @@ func runCreate(ctx context.Context, args []string) {
 	token := cmd.String("token", "", "turso auth token")
 	dir := cmd.String("dir", "migrations", "directory path")
 	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	timestamp := cmd.Bool("timestamp", false, "version the migration with a UTC timestamp instead of the next number")
 	cmd.Parse(args)
 
 	var createName string
@@ -237,26 +274,26 @@ func runCreate(ctx context.Context, args []string) {
 	}
 
 	if createName == "" {
-		log.Fatal("File name required.")
+		fatalf("File name required.")
 	}
 
 	schemaPath := filepath.Join(*rdir, "db.schema")
-	conn, dbtype, err := Conn2DB(schemaPath, *db, *url)
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
 	if err != nil {
-		log.Fatalf("Error connecting: %v", err)
+		fatalf("Error connecting: %v", err)
 	}
 
 	if dbtype == "tursosync" {
 		conn.Close()
 
-		remoteConn, err := getTursoRemoteConn(schemaPath, *remote, *token)
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
 		if err != nil {
-			log.Fatalf("Failed to connect to Remote Primary: %v", err)
+			fatalf("Failed to connect to Remote Primary: %v", err)
 		}
 		conn = remoteConn
 	}
 	defer conn.Close()
-	dialect := GetDialect(dbtype)
+	dialect := migrateConfig.Dialect(dbtype)
 
 	if *dir == "migrations" {
 		CheckTableExists(ctx, conn, dbtype, *rdir)
@@ -266,33 +303,26 @@ func runCreate(ctx context.Context, args []string) {
 	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
 		err := os.MkdirAll(dirPath, 0700)
 		if err != nil {
-			log.Fatalf("Error creating %s: %v\n", dirPath, err)
+			fatalf("Error creating %s: %v\n", dirPath, err)
 		}
 	}
 
 	entries, err := os.ReadDir(dirPath)
 	if err != nil {
-		log.Fatalf("Failed to read directory '%s': %v", dirPath, err)
+		fatalf("Failed to read directory '%s': %v", dirPath, err)
 	}
 
-	maxPrefix := -1
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			parts := strings.SplitN(entry.Name(), "_", 2)
-			if len(parts) > 0 {
-				prefix, err := strconv.Atoi(parts[0])
-				if err == nil && prefix > maxPrefix {
-					maxPrefix = prefix
-				}
-			}
+	useTimestamp := *timestamp
+	if !isFlagPassed(cmd, "timestamp") {
+		if useTimestamp, err = useTimestampVersions(schemaPath); err != nil {
+			fatalf("Error reading versioning config: %v\n", err)
 		}
 	}
-	fileCount := maxPrefix + 1
 
-	fileName := fmt.Sprintf("%d_%s.sql", fileCount, createName)
+	fileName := fmt.Sprintf("%s_%s.sql", migrate.NextMigrationPrefix(entries, useTimestamp), createName)
 	schemaFile, err := os.Create(filepath.Join(dirPath, fileName))
 	if err != nil {
-		log.Fatalf("Error creating file: %v\n", err)
+		fatalf("Error creating file: %v\n", err)
 	}
 	defer schemaFile.Close()
 
@@ -300,14 +330,15 @@ func runCreate(ctx context.Context, args []string) {
 		template := "\n\n-- schema rollback\n\n"
 		_, err = schemaFile.WriteString(template)
 		if err != nil {
-			log.Fatalf("Error writing template to file: %v", err)
+			fatalf("Error writing template to file: %v", err)
 		}
 
 		_, err = conn.ExecContext(ctx, dialect.Insert, fileName, false)
 		if err != nil {
-			log.Fatalf("Error executing SQL: %v\n", err)
+			fatalf("Error executing SQL: %v\n", err)
 		}
 	}
+	setResult("file", filepath.Join(dirPath, fileName))
 	fmt.Printf("Schema successfully created sql file %s\n", fileName)
 }
 
@@ -325,7 +356,7 @@ func runConfig(args []string) {
 	if *url != "" {
 		file, err := os.OpenFile(envPath, os.O_RDWR|os.O_CREATE, 0600)
 		if err != nil {
-			log.Fatalf("Error opening .env: %v", err)
+			fatalf("Error opening .env: %v", err)
 		}
 		defer file.Close()
 
@@ -359,7 +390,7 @@ func runConfig(args []string) {
 	if *db != "" {
 		file, err := os.OpenFile(schemaPath, os.O_RDWR, 0600)
 		if err != nil {
-			log.Fatalf("Error opening schema file: %v", err)
+			fatalf("Error opening schema file: %v", err)
 		}
 		defer file.Close()
 
@@ -402,6 +433,8 @@ func runStudio(args []string) {
 	remote := cmd.String("remote", "", "remote turso url")
 	token := cmd.String("token", "", "turso auth token")
 	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	yes := cmd.Bool("yes-i-am-sure", false, "skip the confirmation for protected environments")
 	cmd.Parse(args)
 
 	if *url != "" {
@@ -409,27 +442,37 @@ func runStudio(args []string) {
 	}
 	schemaPath := filepath.Join(*rdir, "db.schema")
 
-	conn, dbtype, err := Conn2DB(schemaPath, *db, *url)
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		fatalf("Error connecting to database: %v", err)
 	}
 
 	if dbtype == "tursosync" {
 		fmt.Println("🚀 Turso Sync detected: Connecting Studio directly to the Remote Primary...")
 		conn.Close()
 
-		remoteConn, err := getTursoRemoteConn(schemaPath, *remote, *token)
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
 		if err != nil {
-			log.Fatalf("Failed to connect to Remote Primary: %v", err)
+			fatalf("Failed to connect to Remote Primary: %v", err)
 		}
 		conn = remoteConn
 	}
 
 	defer conn.Close()
 
-	p := tea.NewProgram(initialModel(conn, dbtype))
+	studio := initialModel(conn, dbtype)
+	if _, protected, err := protectedTarget(schemaPath, *env, *url); err != nil {
+		fatalf("Error: %v", err)
+	} else if protected && !*yes {
+		// Browsing a protected environment is fine, editing it needs the same confirmation as a migrate.
+		if err := confirmProtected(schemaPath, *env, *url, false, "enable edits (or press Enter to open read-only)"); err != nil {
+			studio.readOnly = "Read-only: this is a protected environment. Restart with --yes-i-am-sure to edit."
+		}
+	}
+
+	p := tea.NewProgram(studio)
 	if _, err := p.Run(); err != nil {
-		log.Fatalf("Error running studio: %v", err)
+		fatalf("Error running studio: %v", err)
 	}
 }
 
@@ -440,6 +483,18 @@ func runMigrate(ctx context.Context, args []string) {
 	remote := cmd.String("remote", "", "remote turso url")
 	token := cmd.String("token", "", "turso auth token")
 	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	yes := cmd.Bool("yes-i-am-sure", false, "skip the confirmation for protected environments")
+	repair := cmd.Bool("repair", false, "record current checksums of modified migration files")
+	lockTimeout := cmd.Duration("lock-timeout", time.Minute, "how long to wait for another migration to finish")
+	to := cmd.String("to", "", "apply pending migrations up to and including this one")
+	allowOutOfOrder := cmd.Bool("allow-out-of-order", false, "apply pending migrations older than the latest applied one")
+	dryRun := cmd.Bool("dry-run", false, "print the SQL that would run without executing it")
+	planFile := cmd.String("plan", "", "with -dry-run, also write the SQL to this file")
+	verifyRollback := cmd.Bool("verify-rollback", false, "apply, roll back and reapply pending migrations on a scratch database first")
+	scratchURL := cmd.String("scratch-url", "", "empty database for -verify-rollback (defaults to a temporary copy for sqlite)")
+	var vars varFlags
+	cmd.Var(&vars, "var", "placeholder value as name=value, can be repeated")
 
 	var targetFile string
 	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
@@ -453,18 +508,18 @@ func runMigrate(ctx context.Context, args []string) {
 	}
 
 	schemaPath := filepath.Join(*rdir, "db.schema")
-	conn, dbtype, err := Conn2DB(schemaPath, *db, *url)
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
 	if err != nil {
-		log.Fatalf("Error connecting: %v", err)
+		fatalf("Error connecting: %v", err)
 	}
 
 	if dbtype == "tursosync" {
 		fmt.Println("🚀 Turso Sync detected: Routing schema migration to the Remote Primary...")
 		conn.Close()
 
-		remoteConn, err := getTursoRemoteConn(schemaPath, *remote, *token)
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
 		if err != nil {
-			log.Fatalf("Failed to connect to Remote Primary: %v", err)
+			fatalf("Failed to connect to Remote Primary: %v", err)
 		}
 		conn = remoteConn
 		defer conn.Close()
@@ -472,178 +527,354 @@ func runMigrate(ctx context.Context, args []string) {
 		defer conn.Close()
 	}
 
-	CheckTableExists(ctx, conn, dbtype, *rdir)
-	dialect := GetDialect(dbtype)
-
-	migrationsDir := filepath.Join(*rdir, "migrations")
-	localMigrationFiles, err := os.ReadDir(migrationsDir)
-	if err != nil {
-		log.Fatalf("Error reading migrations directory '%s': %v\n", migrationsDir, err)
-	}
-
-	dbMigrationFiles := make(map[string]bool)
-
-	rows, err := conn.QueryContext(ctx, "SELECT file FROM _schema_migrations")
-	if err != nil {
-		log.Fatalf("Error querying _schema_migrations table: %v\n", err)
+	m := newMigrator(conn, dbtype, *rdir, "migrations")
+	if m.Vars, err = migrationVars(schemaPath, *env, vars); err != nil {
+		fatalf("Error reading placeholder values: %v", err)
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var filename string
-		if err := rows.Scan(&filename); err != nil {
-			log.Fatalf("Error scanning migration file from DB: %v\n", err)
+	if *verifyRollback {
+		if *scratchURL, err = configuredScratchURL(schemaPath, *env, *scratchURL); err != nil {
+			fatalf("Error reading scratch_url: %v", err)
 		}
-		dbMigrationFiles[filename] = true
 	}
 
-	for _, entry := range localMigrationFiles {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
-			if _, exists := dbMigrationFiles[entry.Name()]; !exists {
-				_, err = conn.ExecContext(ctx, dialect.Insert, entry.Name(), false)
+	migrationFileName := targetFile
+	if migrationFileName != "" && !strings.HasSuffix(migrationFileName, ".sql") {
+		migrationFileName += ".sql"
+	}
+
+	// The files a dry run prints and --verify-rollback checks on a scratch database.
+	var files []string
+	if *dryRun || *verifyRollback {
+		files = []string{migrationFileName}
+		if migrationFileName == "" {
+			files, err = m.Pending(ctx, false)
+			if err != nil {
+				fatalf("Error resolving pending migrations: %v\n", err)
+			}
+			if files, err = migrate.UpTo(files, *to); err != nil {
+				fatalf("Error resolving pending migrations: %v\n", err)
+			}
+			if _, err := m.OutOfOrder(ctx, files, *allowOutOfOrder); err != nil {
+				fatalf("Error checking migration order: %v%s\n", err, errorHint(err))
+			}
+			if *to == "" {
+				repeatable, err := m.PendingRepeatable(ctx)
 				if err != nil {
-					fmt.Printf("Warning: Could not add migration file '%s' to _schema_migrations table: %v\n", entry.Name(), err)
-				} else {
-					fmt.Printf("Added new migration file '%s' to _schema_migrations table.\n", entry.Name())
+					fatalf("Error resolving repeatable migrations: %v\n", err)
 				}
+				files = append(files, repeatable...)
 			}
 		}
 	}
-
-	if targetFile != "" {
-		migrationFileName := targetFile
-		if !strings.HasSuffix(migrationFileName, ".sql") {
-			migrationFileName += ".sql"
+	if *dryRun {
+		printDryRun(m, files, false, *planFile)
+		if *verifyRollback {
+			verifyRollbacks(ctx, m, files, *scratchURL)
 		}
+		return
+	}
 
-		fileP := filepath.Join(*rdir, "migrations", migrationFileName)
-		sqlFile, err := os.ReadFile(fileP)
-		if err != nil {
-			log.Fatalf("Error reading SQL file: %v\n", err)
-		}
+	if err := confirmProtected(schemaPath, *env, *url, *yes, "migrate"); err != nil {
+		fatalf("Error: %v", err)
+	}
+	if *verifyRollback {
+		verifyRollbacks(ctx, m, files, *scratchURL)
+	}
 
-		sqlContent := string(sqlFile)
-		migrationSQL := strings.Split(sqlContent, "-- schema rollback")[0]
+	writeInitFile(ctx, conn, dbtype, *rdir)
 
-		isSQLite := dbtype == "sqlite" || dbtype == "libsql" || dbtype == "turso" || dbtype == "tursosync"
-		if isSQLite {
-			_, _ = conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF;")
+	applied := []string{}
+	setResult("applied", applied)
+	repeatableApplied := false
+	_, err = m.Migrate(ctx, migrate.MigrateOptions{
+		File:            migrationFileName,
+		To:              *to,
+		AllowOutOfOrder: *allowOutOfOrder,
+		Repair:          *repair,
+		LockTimeout:     *lockTimeout,
+		OnApplied: func(file string) error {
+			applied = append(applied, file)
+			setResult("applied", applied)
+			// Repeatable migrations run last, db.schema is refreshed once after all of them.
+			if strings.HasPrefix(file, migrate.RepeatablePrefix) {
+				repeatableApplied = true
+				fmt.Printf("Schema successfully applied %s\n", file)
+				return nil
+			}
+			if err := PullDBSchema(ctx, conn, dbtype, schemaPath); err != nil {
+				return fmt.Errorf("pulling DB schema after migration %s: %w", file, err)
+			}
+			if file == "0_init.sql" {
+				fmt.Println("Schema DB successfully initialized")
+			} else {
+				fmt.Printf("Schema successfully migrated %s\n", file)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		fatalf("Migration failed: %v%s\n", err, errorHint(err))
+	}
+	if len(applied) == 0 {
+		fmt.Println("No pending migrations found.")
+		return
+	}
+	if repeatableApplied {
+		if err := PullDBSchema(ctx, conn, dbtype, schemaPath); err != nil {
+			fatalf("Error pulling DB schema after repeatable migrations: %v\n", err)
 		}
+	}
 
-		tx, err := conn.BeginTx(ctx, nil)
-		if err != nil {
-			log.Fatalf("Error starting transaction: %v", err)
+	// --- NEW: Auto-Sync Local Replica ---
+	if dbtype == "tursosync" {
+		fmt.Println("📥 Auto-syncing new schema to local database...")
+		syncDb, err := initTursoSync(schemaPath, *env, *url, *remote, *token)
+		if err == nil {
+			if _, err := syncDb.Pull(ctx); err != nil {
+				fmt.Printf("⚠️ Warning: Pull failed: %v\n", err)
+			}
+			// CRITICAL: Flush the WAL to disk so the local db sees the changes!
+			if err := syncDb.Checkpoint(ctx); err != nil {
+				fmt.Printf("⚠️ Warning: Checkpoint failed: %v\n", err)
+			}
+			fmt.Println("✅ Local database successfully synced.")
+		} else {
+			fmt.Printf("⚠️ Warning: Failed to auto-sync local replica: %v\n", err)
 		}
-		defer tx.Rollback()
+	}
+}
 
-		_, err = tx.ExecContext(ctx, migrationSQL)
-		if err != nil {
-			log.Fatalf("Error executing SQL (rolled back): %v\n", err)
-		}
+func runRollback(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("rollback", flag.ExitOnError)
+	db := cmd.String("db", "", "database type")
+	url := cmd.String("url", "", "connection url")
+	remote := cmd.String("remote", "", "remote turso url")
+	token := cmd.String("token", "", "turso auth token")
+	dir := cmd.String("dir", "migrations", "migrations directory")
+	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	yes := cmd.Bool("yes-i-am-sure", false, "skip the confirmation for protected environments")
+	repair := cmd.Bool("repair", false, "record current checksums of modified migration files")
+	lockTimeout := cmd.Duration("lock-timeout", time.Minute, "how long to wait for another migration to finish")
+	steps := cmd.Int("steps", 1, "number of migrations to roll back")
+	to := cmd.String("to", "", "roll back every migration applied after this one")
+	dryRun := cmd.Bool("dry-run", false, "print the rollback SQL that would run without executing it")
+	planFile := cmd.String("plan", "", "with -dry-run, also write the SQL to this file")
+	var vars varFlags
+	cmd.Var(&vars, "var", "placeholder value as name=value, can be repeated")
 
-		_, err = tx.ExecContext(ctx, dialect.Update, true, migrationFileName)
-		if err != nil {
-			log.Fatalf("Error updating status (rolled back): %v\n", err)
+	var targetFile string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		targetFile = args[0]
+		cmd.Parse(args[1:])
+	} else {
+		cmd.Parse(args)
+		if len(cmd.Args()) > 0 {
+			targetFile = cmd.Args()[0]
 		}
+	}
 
-		if err := tx.Commit(); err != nil {
-			log.Fatalf("Error committing transaction: %v\n", err)
-		}
+	schemaPath := filepath.Join(*rdir, "db.schema")
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
+	if err != nil {
+		fatalf("Error connecting: %v", err)
+	}
 
-		if isSQLite {
-			_, _ = conn.ExecContext(ctx, "PRAGMA foreign_keys=ON;")
-		}
+	if dbtype == "tursosync" {
+		fmt.Println("🚀 Turso Sync detected: Routing rollback to the Remote Primary...")
+		conn.Close()
 
-		err = PullDBSchema(ctx, conn, dbtype, schemaPath)
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
 		if err != nil {
-			log.Fatalf("Error pulling DB schema after migration: %v\n", err)
+			fatalf("Failed to connect to Remote Primary: %v", err)
 		}
-		fmt.Printf("Schema successfully migrated %s\n", migrationFileName)
-
+		conn = remoteConn
+		defer conn.Close()
 	} else {
-		rows, err := conn.QueryContext(ctx, `SELECT file FROM _schema_migrations WHERE migrated = false ORDER BY id ASC`)
-		if err != nil {
-			log.Fatalf("Error executing SQL query for pending migrations: %v\n", err)
-		}
+		defer conn.Close()
+	}
 
-		type Files struct{ Name string }
-		files := []Files{}
-		for rows.Next() {
-			var fName string
-			err = rows.Scan(&fName)
-			if err != nil {
-				log.Fatalf("Error scanning row for pending migration file: %v\n", err)
+	m := newMigrator(conn, dbtype, *rdir, *dir)
+	if m.Vars, err = migrationVars(schemaPath, *env, vars); err != nil {
+		fatalf("Error reading placeholder values: %v", err)
+	}
+
+	if *dryRun {
+		files := []string{strings.TrimSuffix(targetFile, ".sql") + ".sql"}
+		if targetFile == "" {
+			if files, err = m.RollbackTargets(ctx, *steps, *to); err != nil {
+				fatalf("Error finding migrations to rollback: %v\n", err)
 			}
-			files = append(files, Files{Name: fName})
 		}
-		rows.Close()
+		printDryRun(m, files, true, *planFile)
+		return
+	}
 
-		if len(files) == 0 {
-			fmt.Println("No pending migrations found.")
-			return
+	if err := confirmProtected(schemaPath, *env, *url, *yes, "roll back"); err != nil {
+		fatalf("Error: %v", err)
+	}
+	if *dir == "migrations" {
+		writeInitFile(ctx, conn, dbtype, *rdir)
+	}
+
+	undone := []string{}
+	setResult("rolled_back", undone)
+	_, err = m.Rollback(ctx, migrate.RollbackOptions{
+		File:        targetFile,
+		Steps:       *steps,
+		To:          *to,
+		Repair:      *repair,
+		Untracked:   *dir != "migrations",
+		LockTimeout: *lockTimeout,
+		OnRolledBack: func(file string) error {
+			undone = append(undone, file)
+			setResult("rolled_back", undone)
+			fmt.Printf("Successfully rolled back migration %s\n", file)
+			return nil
+		},
+	})
+	if err != nil {
+		if len(undone) == 0 {
+			fatalf("Rollback failed: %v%s\n", err, errorHint(err))
+		}
+		if err := PullDBSchema(ctx, conn, dbtype, schemaPath); err != nil {
+			fmt.Printf("Warning: Error pulling DB schema after rollback: %v\n", err)
 		}
+		fatalf("Rollback failed: %v%s\nRolled back %d migration(s) before the failure: %s\n", err, errorHint(err), len(undone), strings.Join(undone, ", "))
+	}
+	if len(undone) == 0 {
+		log.Println("No migrations to rollback.")
+		return
+	}
 
-		for _, entry := range files {
-			err := func() error {
-				fileP := filepath.Join(*rdir, "migrations", entry.Name)
-				sqlFile, err := os.ReadFile(fileP)
-				if err != nil {
-					return fmt.Errorf("reading file: %w", err)
-				}
+	err = PullDBSchema(ctx, conn, dbtype, schemaPath)
+	if err != nil {
+		fatalf("Error pulling DB schema after rollback: %v\n", err)
+	}
 
-				sqlContent := string(sqlFile)
-				migrationSQL := strings.Split(sqlContent, "-- schema rollback")[0]
+	if dbtype == "tursosync" {
+		fmt.Println("📥 Auto-syncing rolled back schema to local database...")
+		syncDb, err := initTursoSync(schemaPath, *env, *url, *remote, *token)
+		if err == nil {
+			if _, err := syncDb.Pull(ctx); err != nil {
+				fmt.Printf("⚠️ Warning: Pull failed: %v\n", err)
+			}
+			if err := syncDb.Checkpoint(ctx); err != nil {
+				fmt.Printf("⚠️ Warning: Checkpoint failed: %v\n", err)
+			}
+			fmt.Println("✅ Local database successfully synced.")
+		} else {
+			fmt.Printf("⚠️ Warning: Failed to auto-sync local replica: %v\n", err)
+		}
+	}
+}
 
-				isSQLite := dbtype == "sqlite" || dbtype == "libsql" || dbtype == "turso" || dbtype == "tursosync"
-				if isSQLite {
-					_, _ = conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF;")
-				}
+// runRedo rolls back a migration and applies it again, the latest applied one by default.
+func runRedo(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("redo", flag.ExitOnError)
+	db := cmd.String("db", "", "database type")
+	url := cmd.String("url", "", "connection url")
+	remote := cmd.String("remote", "", "remote turso url")
+	token := cmd.String("token", "", "turso auth token")
+	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	yes := cmd.Bool("yes-i-am-sure", false, "skip the confirmation for protected environments")
+	lockTimeout := cmd.Duration("lock-timeout", time.Minute, "how long to wait for another migration to finish")
+	var vars varFlags
+	cmd.Var(&vars, "var", "placeholder value as name=value, can be repeated")
 
-				tx, err := conn.BeginTx(ctx, nil)
-				if err != nil {
-					return fmt.Errorf("starting transaction: %w", err)
-				}
-				defer tx.Rollback()
+	var targetFile string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		targetFile = args[0]
+		cmd.Parse(args[1:])
+	} else {
+		cmd.Parse(args)
+		if len(cmd.Args()) > 0 {
+			targetFile = cmd.Args()[0]
+		}
+	}
 
-				if _, err := tx.ExecContext(ctx, migrationSQL); err != nil {
-					return fmt.Errorf("executing migration SQL: %w", err)
-				}
+	schemaPath := filepath.Join(*rdir, "db.schema")
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
+	if err != nil {
+		fatalf("Error connecting: %v", err)
+	}
 
-				if _, err := tx.ExecContext(ctx, dialect.Update, true, entry.Name); err != nil {
-					return fmt.Errorf("updating migration status: %w", err)
-				}
+	if dbtype == "tursosync" {
+		fmt.Println("🚀 Turso Sync detected: Routing redo to the Remote Primary...")
+		conn.Close()
 
-				if err := tx.Commit(); err != nil {
-					return err
-				}
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
+		if err != nil {
+			fatalf("Failed to connect to Remote Primary: %v", err)
+		}
+		conn = remoteConn
+	}
+	defer conn.Close()
 
-				if isSQLite {
-					_, _ = conn.ExecContext(ctx, "PRAGMA foreign_keys=ON;")
-				}
+	m := newMigrator(conn, dbtype, *rdir, "migrations")
+	if m.Vars, err = migrationVars(schemaPath, *env, vars); err != nil {
+		fatalf("Error reading placeholder values: %v", err)
+	}
 
-				return nil
-			}()
+	if err := confirmProtected(schemaPath, *env, *url, *yes, "redo"); err != nil {
+		fatalf("Error: %v", err)
+	}
+	unlock := lockMigrations(ctx, m, *lockTimeout)
+	defer unlock()
 
-			if err != nil {
-				log.Fatalf("Migration failed for %s: %v", entry.Name, err)
-			}
+	CheckTableExists(ctx, conn, dbtype, *rdir)
 
-			err = PullDBSchema(ctx, conn, dbtype, schemaPath)
-			if err != nil {
-				log.Fatalf("Error pulling DB schema after migration %s: %v\n", entry.Name, err)
+	file := targetFile
+	if file == "" {
+		files, err := m.RollbackTargets(ctx, 1, "")
+		if err != nil {
+			fatalf("Error finding the latest migration: %v\n", err)
+		}
+		if len(files) == 0 {
+			setResult("redone", nil)
+			log.Println("No migrations to redo.")
+			return
+		}
+		file = files[0]
+	}
+	file = strings.TrimSuffix(file, ".sql") + ".sql"
+
+	// The file being redone is expected to have changed, every other applied file is not.
+	if err := m.VerifyChecksums(ctx, false); err != nil {
+		var checksumErr *migrate.ChecksumError
+		if !errors.As(err, &checksumErr) {
+			fatalf("Error verifying migrations: %v\n", err)
+		}
+		var others []migrate.ChecksumMismatch
+		for _, mm := range checksumErr.Mismatches {
+			if mm.File != file {
+				others = append(others, mm)
 			}
-			fmt.Printf("Schema successfully migrated %s\n", entry.Name)
+		}
+		if len(others) > 0 {
+			err = &migrate.ChecksumError{Mismatches: others}
+			fatalf("Error verifying migrations: %v%s\n", err, errorHint(err))
 		}
 	}
 
-	// --- NEW: Auto-Sync Local Replica ---
+	if err := m.RedoFile(ctx, file); err != nil {
+		fatalf("Redo failed for %s: %v%s\n", file, err, errorHint(err))
+	}
+	setResult("redone", file)
+	fmt.Printf("Successfully redid migration %s\n", file)
+
+	if err := PullDBSchema(ctx, conn, dbtype, schemaPath); err != nil {
+		fatalf("Error pulling DB schema after redo: %v\n", err)
+	}
+
 	if dbtype == "tursosync" {
-		fmt.Println("📥 Auto-syncing new schema to local database...")
-		syncDb, err := initTursoSync(schemaPath, *url, *remote, *token)
+		fmt.Println("📥 Auto-syncing redone schema to local database...")
+		syncDb, err := initTursoSync(schemaPath, *env, *url, *remote, *token)
 		if err == nil {
 			if _, err := syncDb.Pull(ctx); err != nil {
 				fmt.Printf("⚠️ Warning: Pull failed: %v\n", err)
 			}
-			// CRITICAL: Flush the WAL to disk so the local db sees the changes!
 			if err := syncDb.Checkpoint(ctx); err != nil {
 				fmt.Printf("⚠️ Warning: Checkpoint failed: %v\n", err)
 			}
@@ -654,133 +885,578 @@ func runMigrate(ctx context.Context, args []string) {
 	}
 }
 
-func runRollback(ctx context.Context, args []string) {
-	cmd := flag.NewFlagSet("rollback", flag.ExitOnError)
+// runBaseline adopts an existing database by recording the migrations up to -to as applied
+// without running them.
+func runBaseline(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("baseline", flag.ExitOnError)
 	db := cmd.String("db", "", "database type")
 	url := cmd.String("url", "", "connection url")
 	remote := cmd.String("remote", "", "remote turso url")
 	token := cmd.String("token", "", "turso auth token")
-	dir := cmd.String("dir", "migrations", "migrations directory")
 	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	yes := cmd.Bool("yes-i-am-sure", false, "skip the confirmation for protected environments")
+	lockTimeout := cmd.Duration("lock-timeout", time.Minute, "how long to wait for another migration to finish")
+	to := cmd.String("to", "", "last migration already in place in the database")
+	cmd.Parse(args)
 
-	var targetFile string
-	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
-		targetFile = args[0]
-		cmd.Parse(args[1:])
-	} else {
-		cmd.Parse(args)
-		if len(cmd.Args()) > 0 {
-			targetFile = cmd.Args()[0]
+	if *to == "" {
+		fatalf("Error: baseline needs -to, the last migration already in place (e.g. schema baseline -to 12)")
+	}
+
+	schemaPath := filepath.Join(*rdir, "db.schema")
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
+	if err != nil {
+		fatalf("Error connecting: %v", err)
+	}
+
+	if dbtype == "tursosync" {
+		conn.Close()
+
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
+		if err != nil {
+			fatalf("Failed to connect to Remote Primary: %v", err)
 		}
+		conn = remoteConn
+	}
+	defer conn.Close()
+
+	if err := confirmProtected(schemaPath, *env, *url, *yes, "baseline"); err != nil {
+		fatalf("Error: %v", err)
+	}
+	writeInitFile(ctx, conn, dbtype, *rdir)
+
+	m := newMigrator(conn, dbtype, *rdir, "migrations")
+	files, err := m.Baseline(ctx, migrate.BaselineOptions{To: *to, LockTimeout: *lockTimeout})
+	for _, file := range files {
+		fmt.Printf("Marked %s as migrated\n", file)
+	}
+	if err != nil {
+		fatalf("Baseline failed: %v%s\n", err, errorHint(err))
+	}
+	setResult("baselined", nonNil(files))
+
+	if err := PullDBSchema(ctx, conn, dbtype, schemaPath); err != nil {
+		fatalf("Error pulling DB schema after baseline: %v\n", err)
+	}
+	fmt.Printf("Baselined %d migration(s) without running them.\n", len(files))
+}
+
+// runSquash replaces the migrations up to -to with one migration that recreates the schema they
+// produce, built on a scratch database.
+func runSquash(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("squash", flag.ExitOnError)
+	db := cmd.String("db", "", "database type")
+	url := cmd.String("url", "", "connection url")
+	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	yes := cmd.Bool("yes-i-am-sure", false, "skip the confirmation for protected environments")
+	lockTimeout := cmd.Duration("lock-timeout", time.Minute, "how long to wait for another migration to finish")
+	to := cmd.String("to", "", "last migration to squash")
+	scratchURL := cmd.String("scratch-url", "", "empty database to build the squash on (defaults to a temporary file for sqlite)")
+	var vars varFlags
+	cmd.Var(&vars, "var", "placeholder value as name=value, can be repeated")
+	cmd.Parse(args)
+
+	if *to == "" {
+		fatalf("Error: squash needs -to, the last migration to replace (e.g. schema squash -to 120)")
+	}
+
+	schemaPath := filepath.Join(*rdir, "db.schema")
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
+	if err != nil {
+		fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	if err := confirmProtected(schemaPath, *env, *url, *yes, "squash"); err != nil {
+		fatalf("Error: %v", err)
+	}
+	m := newMigrator(conn, dbtype, *rdir, "migrations")
+	if m.Vars, err = migrationVars(schemaPath, *env, vars); err != nil {
+		fatalf("Error reading placeholder values: %v", err)
+	}
+	unlock := lockMigrations(ctx, m, *lockTimeout)
+	defer unlock()
+
+	CheckTableExists(ctx, conn, dbtype, *rdir)
+
+	if err := m.VerifyChecksums(ctx, false); err != nil {
+		fatalf("Error verifying migrations: %v%s\n", err, errorHint(err))
+	}
+
+	states, err := m.Status(ctx)
+	if err != nil {
+		fatalf("Error reading migration status: %v\n", err)
+	}
+	status := make(map[string]string)
+	var onDisk []string
+	for _, st := range states {
+		status[st.File] = st.Status
+		if st.Status != migrate.StatusMissing && st.File != "0_init.sql" && !strings.HasPrefix(st.File, migrate.RepeatablePrefix) {
+			onDisk = append(onDisk, st.File)
+		}
+	}
+	files, err := migrate.UpTo(onDisk, *to)
+	if err != nil {
+		fatalf("Error resolving migrations to squash: %v\n", err)
+	}
+	if len(files) < 2 {
+		fatalf("Error: nothing to squash, %s is the first migration", files[0])
+	}
+	applied := 0
+	for _, file := range files {
+		if status[file] == migrate.StatusApplied {
+			applied++
+		}
+	}
+	if applied > 0 && applied < len(files) {
+		fatalf("Error: only %d of the %d migrations up to %s are applied to this database. Migrate or roll back first so they are all applied or all pending", applied, len(files), *to)
+	}
+
+	last := files[len(files)-1]
+	prefix, _, _ := strings.Cut(last, "_")
+	squashFile := prefix + "_squash.sql"
+	migrationsDir := filepath.Join(*rdir, "migrations")
+	squashPath := filepath.Join(migrationsDir, squashFile)
+	if _, err := os.Stat(squashPath); err == nil {
+		fatalf("Error: %s already exists", squashFile)
+	}
+
+	if *scratchURL, err = configuredScratchURL(schemaPath, *env, *scratchURL); err != nil {
+		fatalf("Error reading scratch_url: %v", err)
+	}
+	scratch, cleanup, err := openScratch(dbtype, *scratchURL)
+	if err != nil {
+		fatalf("Error opening scratch database: %v", err)
+	}
+	defer cleanup()
+
+	content, err := m.Squash(ctx, scratch, files)
+	if err != nil {
+		fatalf("Error building squashed migration: %v%s", err, errorHint(err))
+	}
+
+	// The old files are only removed once the squash is in place and recorded, so a failure
+	// part way leaves every migration on disk.
+	if err := writeFileAtomic(squashPath, []byte(content)); err != nil {
+		fatalf("Error writing %s: %v", squashFile, err)
+	}
+	if _, err := m.ReconcileSquashes(ctx); err != nil {
+		os.Remove(squashPath)
+		fatalf("Error recording squashed migration: %v\n", err)
+	}
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(migrationsDir, file)); err != nil {
+			fatalf("Error removing %s: %v\n%s is already in place, delete the remaining squashed files by hand", file, err, squashFile)
+		}
+	}
+	setResult("file", filepath.Join(migrationsDir, squashFile))
+	setResult("replaced", files)
+	fmt.Printf("Squashed %d migrations into %s\n", len(files), squashFile)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place, so path
+// is never left half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Removing it fails harmlessly once it has been renamed.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
 	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// verifyRollbacks applies, rolls back and reapplies files on a scratch copy of the database and
+// exits if a rollback doesn't restore the schema. Repeatable migrations have no rollback and are
+// skipped.
+func verifyRollbacks(ctx context.Context, m *migrate.Migrator, files []string, scratchURL string) {
+	var versioned []string
+	for _, file := range files {
+		if !strings.HasPrefix(file, migrate.RepeatablePrefix) {
+			versioned = append(versioned, file)
+		}
+	}
+	if len(versioned) == 0 {
+		return
+	}
+
+	scratch, cleanup, err := scratchCopy(ctx, m, scratchURL)
+	if err != nil {
+		fatalf("Error preparing scratch database: %v", err)
+	}
+	defer cleanup()
+
+	if err := m.VerifyRollback(ctx, scratch, versioned); err != nil {
+		cleanup()
+		fatalf("Rollback verification failed: %v%s", err, errorHint(err))
+	}
+	setResult("verified_rollback", versioned)
+	fmt.Printf("Verified the rollback of %d migration(s) on a scratch database.\n", len(versioned))
+}
+
+// scratchCopy opens a scratch database with the schema of m.DB. SQLite databases are copied with
+// VACUUM INTO; otherwise the tables are recreated in the empty database at url.
+func scratchCopy(ctx context.Context, m *migrate.Migrator, url string) (*sql.DB, func(), error) {
+	isSQLite := m.DBType == "sqlite" || m.DBType == "libsql" || m.DBType == "turso" || m.DBType == "tursosync"
+	if isSQLite && url == "" {
+		dir, err := os.MkdirTemp("", "schema-scratch-")
+		if err != nil {
+			return nil, nil, err
+		}
+		path := filepath.Join(dir, "scratch.db")
+		// Remote libSQL databases can't be vacuumed into a local file, they fall through to a clone.
+		if _, err := m.DB.ExecContext(ctx, "VACUUM INTO ?", path); err == nil {
+			conn, err := migrate.Open("sqlite", path)
+			if err != nil {
+				os.RemoveAll(dir)
+				return nil, nil, err
+			}
+			return conn, func() {
+				conn.Close()
+				os.RemoveAll(dir)
+			}, nil
+		}
+		os.RemoveAll(dir)
+	}
+
+	scratch, cleanup, err := openScratch(m.DBType, url)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.CloneSchema(ctx, scratch); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return scratch, cleanup, nil
+}
+
+// shadowSchema returns the schema produced by replaying every migration of m on a scratch
+// database, so stray changes to the live database don't end up in a generated migration.
+func shadowSchema(ctx context.Context, m *migrate.Migrator, schemaPath, env, url string, vars varFlags) *migrate.Database {
+	var err error
+	if m.Vars, err = migrationVars(schemaPath, env, vars); err != nil {
+		fatalf("Error reading placeholder values: %v", err)
+	}
+	if url, err = configuredScratchURL(schemaPath, env, url); err != nil {
+		fatalf("Error reading scratch_url: %v", err)
+	}
+	shadow, cleanup, err := openScratch(m.DBType, url)
+	if err != nil {
+		fatalf("Error opening shadow database: %v", err)
+	}
+	defer cleanup()
+
+	schema, err := m.ShadowSchema(ctx, shadow)
+	if err != nil {
+		cleanup()
+		fatalf("Error building shadow schema: %v%s", err, errorHint(err))
+	}
+	fmt.Println("Diffing db.schema against the migrations replayed on a shadow database.")
+	return schema
+}
+
+// configuredScratchURL returns url, or the scratch_url setting of db.schema when url is empty.
+func configuredScratchURL(schemaPath, env, url string) (string, error) {
+	if url != "" {
+		return url, nil
+	}
+	lines, err := readConfig(schemaPath, env)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
+	for _, l := range lines {
+		if strings.HasPrefix(l.Text, "scratch_url =") {
+			url = extractConfigValue(l.Text, envRegex)
+		}
+	}
+	return url, nil
+}
+
+// openScratch opens an empty database of type dbtype for building or checking migrations. Without
+// a url, SQLite databases get a temporary file; other databases need one.
+func openScratch(dbtype, url string) (*sql.DB, func(), error) {
+	isSQLite := dbtype == "sqlite" || dbtype == "libsql" || dbtype == "turso" || dbtype == "tursosync"
+	if url != "" {
+		driverType := dbtype
+		if isSQLite {
+			driverType = "sqlite"
+		}
+		conn, err := migrate.Open(driverType, url)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() { conn.Close() }, nil
+	}
+	if !isSQLite {
+		return nil, nil, fmt.Errorf("%s needs an empty database passed with -scratch-url or set as scratch_url in db.schema", dbtype)
+	}
+
+	dir, err := os.MkdirTemp("", "schema-scratch-")
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := migrate.Open("sqlite", filepath.Join(dir, "scratch.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	return conn, func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// newMigrator returns the migration engine for the .sql files in rdir/dir and the repeatable
+// migrations in rdir/repeatable, printing its progress.
+func newMigrator(conn *sql.DB, dbtype, rdir, dir string) *migrate.Migrator {
+	m, err := migrate.New(conn, dbtype, os.DirFS(filepath.Join(rdir, dir)))
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	m.Repeatable = os.DirFS(filepath.Join(rdir, "repeatable"))
+	m.Version = version
+	m.Logf = func(format string, v ...any) { fmt.Printf(format+"\n", v...) }
+	if err := m.Configure(migrateConfig); err != nil {
+		fatalf("Error: %v", err)
+	}
+	return m
+}
+
+// lockMigrations takes the migration lock and makes sure a fatal exit releases it too.
+func lockMigrations(ctx context.Context, m *migrate.Migrator, timeout time.Duration) func() {
+	unlock, err := m.Lock(ctx, timeout)
+	if err != nil {
+		fatalf("Error acquiring migration lock: %v%s", err, errorHint(err))
+	}
+	exitHooks = append(exitHooks, unlock)
+	return unlock
+}
+
+// errorHint suggests the command line fix for errors returned by the migrate package.
+func errorHint(err error) string {
+	var checksumErr *migrate.ChecksumError
+	var orderErr *migrate.OutOfOrderError
+	var lockErr *migrate.LockTimeoutError
+	var placeholderErr *migrate.PlaceholderError
+	var movedErr *migrate.TrackingTableMovedError
+	var squashErr *migrate.UnsquashableError
+	switch {
+	case errors.As(err, &checksumErr):
+		return "\nRestore the original files, or rerun with --repair to accept the current contents"
+	case errors.As(err, &orderErr):
+		return fmt.Sprintf("\nThey were probably merged from another branch after %s ran. Rerun with --allow-out-of-order to apply them anyway", orderErr.Latest)
+	case errors.As(err, &placeholderErr):
+		return "\nPass values with -var name=value, add var name = \"value\" to db.schema, or set the env: variables in .env"
+	case errors.As(err, &lockErr) && lockErr.Table != "":
+		return fmt.Sprintf("\nIf that process is no longer running, remove the stale lock with: schema sql \"DELETE FROM %s\"", lockErr.Table)
+	case errors.As(err, &squashErr):
+		return "\nSquash up to a migration before them, or move views, functions and triggers to schema/repeatable/. No files were changed"
+	case errors.As(err, &movedErr):
+		return fmt.Sprintf("\nRename %s to %s and use the new name in migrations/0_init.sql, or remove migrations_table and migrations_schema from db.schema to keep the old table", movedErr.Existing, movedErr.Table)
+	}
+	return ""
+}
+
+// printDryRun prints the SQL a migrate or rollback would execute and optionally saves it to planFile.
+func printDryRun(m *migrate.Migrator, files []string, rollback bool, planFile string) {
+	setResult("dry_run", true)
+	setResult("files", nonNil(files))
+	if len(files) == 0 {
+		if rollback {
+			fmt.Println("No migrations to rollback.")
+		} else {
+			fmt.Println("No pending migrations found.")
+		}
+		return
+	}
+
+	plan, err := m.Plan(files, rollback)
+	if err != nil {
+		fatalf("Error building dry run plan: %v%s\n", err, errorHint(err))
+	}
+	fmt.Print(plan)
+	setResult("sql", plan)
+
+	if planFile != "" {
+		if err := os.WriteFile(planFile, []byte(plan), 0644); err != nil {
+			fatalf("Error writing plan file: %v\n", err)
+		}
+		fmt.Printf("Plan written to %s\n", planFile)
+	}
+	fmt.Printf("Dry run: %d file(s), nothing was executed.\n", len(files))
+}
+
+func runStatus(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("status", flag.ExitOnError)
+	db := cmd.String("db", "", "database type")
+	url := cmd.String("url", "", "connection url")
+	remote := cmd.String("remote", "", "remote turso url")
+	token := cmd.String("token", "", "turso auth token")
+	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	cmd.Parse(args)
 
 	schemaPath := filepath.Join(*rdir, "db.schema")
-	conn, dbtype, err := Conn2DB(schemaPath, *db, *url)
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
 	if err != nil {
-		log.Fatalf("Error connecting: %v", err)
+		fatalf("Error connecting: %v", err)
 	}
 
 	if dbtype == "tursosync" {
-		fmt.Println("🚀 Turso Sync detected: Routing rollback to the Remote Primary...")
 		conn.Close()
 
-		remoteConn, err := getTursoRemoteConn(schemaPath, *remote, *token)
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
 		if err != nil {
-			log.Fatalf("Failed to connect to Remote Primary: %v", err)
+			fatalf("Failed to connect to Remote Primary: %v", err)
 		}
 		conn = remoteConn
-		defer conn.Close()
-	} else {
-		defer conn.Close()
 	}
+	defer conn.Close()
 
-	dialect := GetDialect(dbtype)
-
-	var migrationToRollback string
-	var migrationFileName string
-
-	if targetFile == "" {
-		query := `SELECT file FROM _schema_migrations WHERE migrated = true ORDER BY id DESC LIMIT 1`
-		err := conn.QueryRowContext(ctx, query).Scan(&migrationFileName)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				log.Println("No migrations to rollback.")
-				return
-			}
-			log.Fatalf("Error finding last migration to rollback: %v\n", err)
-		}
-		migrationToRollback = strings.TrimSuffix(migrationFileName, ".sql")
-	} else {
-		migrationToRollback = targetFile
-		migrationToRollback = strings.TrimSuffix(migrationToRollback, ".sql")
-		migrationFileName = migrationToRollback + ".sql"
+	states, err := newMigrator(conn, dbtype, *rdir, "migrations").Status(ctx)
+	if err != nil {
+		fatalf("Error reading migration status: %v\n", err)
+	}
+
+	counts := make(map[string]int)
+	var data [][]string
+	migrations := []map[string]string{}
+	for _, st := range states {
+		counts[st.Status]++
+		data = append(data, []string{st.File, st.Status, st.AppliedAt})
+		migrations = append(migrations, map[string]string{"file": st.File, "status": st.Status, "applied_at": st.AppliedAt})
+	}
+	setResult("migrations", migrations)
+	setResult("counts", map[string]int{
+		migrate.StatusApplied:   counts[migrate.StatusApplied],
+		migrate.StatusModified:  counts[migrate.StatusModified],
+		migrate.StatusPending:   counts[migrate.StatusPending],
+		migrate.StatusUntracked: counts[migrate.StatusUntracked],
+		migrate.StatusMissing:   counts[migrate.StatusMissing],
+	})
+	if len(data) > 0 {
+		fmt.Println(printTable([]string{"file", "status", "applied_at"}, data))
 	}
+	fmt.Printf("%d applied, %d modified, %d pending, %d untracked, %d missing\n",
+		counts[migrate.StatusApplied], counts[migrate.StatusModified], counts[migrate.StatusPending], counts[migrate.StatusUntracked], counts[migrate.StatusMissing])
 
-	fileP := filepath.Join(*rdir, *dir, migrationToRollback+".sql")
-	sqlFile, err := os.ReadFile(fileP)
-	if err != nil {
-		log.Fatalf("Error reading SQL file for rollback: %v\n", err)
+	// Files that changed or disappeared after being applied matter more than pending ones.
+	if counts[migrate.StatusModified] > 0 || counts[migrate.StatusMissing] > 0 {
+		exit(exitDrift)
 	}
+	if counts[migrate.StatusPending] > 0 || counts[migrate.StatusUntracked] > 0 {
+		exit(exitPending)
+	}
+}
 
-	sqlContent := string(sqlFile)
-	parts := strings.Split(sqlContent, "-- schema rollback")
+// runCheck compares the database with the migrations directory and db.schema without changing
+// either, and exits non-zero if they disagree.
+func runCheck(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("check", flag.ExitOnError)
+	db := cmd.String("db", "", "database type")
+	url := cmd.String("url", "", "connection url")
+	remote := cmd.String("remote", "", "remote turso url")
+	token := cmd.String("token", "", "turso auth token")
+	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	cmd.Parse(args)
 
-	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
-		log.Fatalf("Error: No rollback script found in %s.sql", migrationToRollback)
+	schemaPath := filepath.Join(*rdir, "db.schema")
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
+	if err != nil {
+		fatalf("Error connecting: %v", err)
 	}
-	rollbackSQL := parts[1]
 
-	isSQLite := dbtype == "sqlite" || dbtype == "libsql" || dbtype == "turso" || dbtype == "tursosync"
-	if isSQLite {
-		_, _ = conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF;")
+	if dbtype == "tursosync" {
+		conn.Close()
+
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
+		if err != nil {
+			fatalf("Failed to connect to Remote Primary: %v", err)
+		}
+		conn = remoteConn
 	}
+	defer conn.Close()
 
-	tx, err := conn.BeginTx(ctx, nil)
+	states, err := newMigrator(conn, dbtype, *rdir, "migrations").Status(ctx)
 	if err != nil {
-		log.Fatalf("Error starting transaction: %v", err)
+		fatalf("Error reading migration status: %v\n", err)
 	}
-	defer tx.Rollback()
-
-	if _, err := tx.ExecContext(ctx, rollbackSQL); err != nil {
-		log.Fatalf("Error executing rollback SQL for %s.sql: %v\n", migrationToRollback, err)
+	currentSchema, err := migrateConfig.InspectSchema(ctx, conn, dbtype)
+	if err != nil {
+		fatalf("Error inspecting current database schema: %v", err)
 	}
-
-	if *dir == "migrations" {
-		if _, err := tx.ExecContext(ctx, dialect.Update, false, migrationFileName); err != nil {
-			log.Fatalf("Error updating migration status after rollback for %s: %v\n", migrationFileName, err)
+	desiredSchema, err := migrate.ParseSchemaFile(schemaPath)
+	if err != nil {
+		fatalf("Error parsing local schema file: %v", err)
+	}
+	drift := migrate.DescribeDrift(migrate.DiffSchemas(currentSchema, desiredSchema))
+
+	var fileDrift, pending bool
+	var migrationData [][]string
+	migrations := []map[string]string{}
+	for _, st := range states {
+		switch st.Status {
+		case migrate.StatusModified, migrate.StatusMissing:
+			fileDrift = true
+		case migrate.StatusPending, migrate.StatusUntracked:
+			pending = true
+		default:
+			continue
 		}
+		migrationData = append(migrationData, []string{st.File, st.Status})
+		migrations = append(migrations, map[string]string{"file": st.File, "status": st.Status})
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Fatalf("Error committing rollback transaction: %v\n", err)
+	directions := map[string]string{
+		migrate.OnlyInDatabase: "only in the database",
+		migrate.OnlyInSchema:   "only in db.schema",
+		migrate.Changed:        "differs",
 	}
-
-	if isSQLite {
-		_, _ = conn.ExecContext(ctx, "PRAGMA foreign_keys=ON;")
+	var schemaData [][]string
+	schemaDrift := []map[string]string{}
+	for _, d := range drift {
+		schemaData = append(schemaData, []string{d.Object, d.Name, directions[d.Direction], d.Detail})
+		schemaDrift = append(schemaDrift, map[string]string{"object": d.Object, "name": d.Name, "direction": d.Direction, "detail": d.Detail})
 	}
+	setResult("migrations", migrations)
+	setResult("drift", schemaDrift)
 
-	err = PullDBSchema(ctx, conn, dbtype, schemaPath)
-	if err != nil {
-		log.Fatalf("Error pulling DB schema after rollback: %v\n", err)
+	if len(migrationData) > 0 {
+		fmt.Println("Migrations that don't match the database:")
+		fmt.Println(printTable([]string{"file", "status"}, migrationData))
+	}
+	if len(schemaData) > 0 {
+		fmt.Printf("Differences between the database and %s:\n", schemaPath)
+		fmt.Println(printTable([]string{"object", "name", "where", "detail"}, schemaData))
 	}
 
-	fmt.Printf("Successfully rolled back migration %s\n", migrationFileName)
-
-	if dbtype == "tursosync" {
-		fmt.Println("📥 Auto-syncing rolled back schema to local database...")
-		syncDb, err := initTursoSync(schemaPath, *url, *remote, *token)
-		if err == nil {
-			if _, err := syncDb.Pull(ctx); err != nil {
-				fmt.Printf("⚠️ Warning: Pull failed: %v\n", err)
-			}
-			if err := syncDb.Checkpoint(ctx); err != nil {
-				fmt.Printf("⚠️ Warning: Checkpoint failed: %v\n", err)
-			}
-			fmt.Println("✅ Local database successfully synced.")
-		} else {
-			fmt.Printf("⚠️ Warning: Failed to auto-sync local replica: %v\n", err)
-		}
+	switch {
+	case fileDrift || len(drift) > 0:
+		fmt.Println("Drift detected. Run `schema pull` to update db.schema from the database, or `schema generate` to migrate the database to db.schema.")
+		exit(exitDrift)
+	case pending:
+		fmt.Println("The database is behind the migrations directory, run `schema migrate`.")
+		exit(exitPending)
 	}
+	fmt.Println("✅ The database, migrations and db.schema are in sync.")
 }
 
 func runPull(ctx context.Context, args []string) {
@@ -790,13 +1466,14 @@ func runPull(ctx context.Context, args []string) {
 	remote := cmd.String("remote", "", "remote turso url")
 	token := cmd.String("token", "", "turso auth token")
 	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
 	cmd.Parse(args)
 
 	schemaPath := filepath.Join(*rdir, "db.schema")
 
-	conn, dbtype, err := Conn2DB(schemaPath, *db, *url)
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
 	if err != nil {
-		log.Fatalf("Error connecting: %v", err)
+		fatalf("Error connecting: %v", err)
 	}
 
 	if dbtype == "tursosync" {
@@ -804,33 +1481,34 @@ func runPull(ctx context.Context, args []string) {
 		conn.Close()
 
 		_ = godotenv.Load()
-		syncDb, err := initTursoSync(schemaPath, *url, *remote, *token)
+		syncDb, err := initTursoSync(schemaPath, *env, *url, *remote, *token)
 		if err != nil {
-			log.Fatalf("Failed to initialize sync engine: %v", err)
+			fatalf("Failed to initialize sync engine: %v", err)
 		}
 
 		fmt.Println("📥 Pulling latest changes from the remote database...")
 		if _, err := syncDb.Pull(ctx); err != nil {
-			log.Fatalf("Pull failed: %v", err)
+			fatalf("Pull failed: %v", err)
 		}
 		// CRITICAL: Flush the WAL to disk!
 		if err := syncDb.Checkpoint(ctx); err != nil {
-			log.Fatalf("Checkpoint failed: %v", err)
+			fatalf("Checkpoint failed: %v", err)
 		}
 		fmt.Println("✅ Successfully pulled data to local replica.")
 
 		// Reconnect so we can proceed with updating the db.schema text file!
-		conn, _, err = Conn2DB(schemaPath, *db, *url)
+		conn, _, err = Conn2DB(schemaPath, *env, *db, *url)
 		if err != nil {
-			log.Fatalf("Error reconnecting after pull: %v", err)
+			fatalf("Error reconnecting after pull: %v", err)
 		}
 	}
 	defer conn.Close()
 
 	err = PullDBSchema(ctx, conn, dbtype, schemaPath)
 	if err != nil {
-		log.Fatalf("Err pulling db schema: %v\n", err)
+		fatalf("Err pulling db schema: %v\n", err)
 	}
+	setResult("schema", schemaPath)
 	fmt.Println("✅ Successfully updated schema.")
 }
 
@@ -841,6 +1519,8 @@ func runRemove(ctx context.Context, args []string) {
 	remote := cmd.String("remote", "", "remote turso url")
 	token := cmd.String("token", "", "turso auth token")
 	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	yes := cmd.Bool("yes-i-am-sure", false, "skip the confirmation for protected environments")
 	cmd.Parse(args)
 
 	var name string
@@ -852,22 +1532,22 @@ func runRemove(ctx context.Context, args []string) {
 		name = cmd.Arg(0)
 	}
 	if name == "" {
-		log.Fatal("Usage: remove <migration_name>")
+		fatalf("Usage: remove <migration_name>")
 	}
 
 	schemaPath := filepath.Join(*rdir, "db.schema")
-	conn, dbtype, err := Conn2DB(schemaPath, *db, *url)
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
 	if err != nil {
-		log.Fatalf("Error connecting: %v", err)
+		fatalf("Error connecting: %v", err)
 	}
 
 	if dbtype == "tursosync" {
 		fmt.Println("🚀 Turso Sync detected: Routing removal to the Remote Primary...")
 		conn.Close()
 
-		remoteConn, err := getTursoRemoteConn(schemaPath, *remote, *token)
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
 		if err != nil {
-			log.Fatalf("Failed to connect to Remote Primary: %v", err)
+			fatalf("Failed to connect to Remote Primary: %v", err)
 		}
 		conn = remoteConn
 		defer conn.Close()
@@ -875,7 +1555,10 @@ func runRemove(ctx context.Context, args []string) {
 		defer conn.Close()
 	}
 
-	dialect := GetDialect(dbtype)
+	if err := confirmProtected(schemaPath, *env, *url, *yes, "remove a migration"); err != nil {
+		fatalf("Error: %v", err)
+	}
+	dialect := migrateConfig.Dialect(dbtype)
 
 	migrationFileName := name
 	if !strings.HasSuffix(migrationFileName, ".sql") {
@@ -885,23 +1568,23 @@ func runRemove(ctx context.Context, args []string) {
 	var migrated bool
 	err = conn.QueryRowContext(ctx, dialect.SelectStatus, migrationFileName).Scan(&migrated)
 	if err != nil && err != sql.ErrNoRows {
-		log.Fatalf("Error checking migration status for %s: %v\n", migrationFileName, err)
+		fatalf("Error checking migration status for %s: %v\n", migrationFileName, err)
 	}
 
 	if err == nil && migrated {
-		log.Fatalf("Cannot remove migration file '%s' because it has already been migrated.", migrationFileName)
+		fatalf("Cannot remove migration file '%s' because it has already been migrated.", migrationFileName)
 	}
 
 	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
-		log.Fatalf("Error starting transaction: %v", err)
+		fatalf("Error starting transaction: %v", err)
 	}
 	defer tx.Rollback()
 
 	if err != sql.ErrNoRows {
 		_, delErr := tx.ExecContext(ctx, dialect.Delete, migrationFileName)
 		if delErr != nil {
-			log.Fatalf("Failed to delete migration record for '%s' from database: %v\n", migrationFileName, delErr)
+			fatalf("Failed to delete migration record for '%s' from database: %v\n", migrationFileName, delErr)
 		}
 	}
 
@@ -911,13 +1594,14 @@ func runRemove(ctx context.Context, args []string) {
 		if os.IsNotExist(removeErr) {
 			fmt.Printf("Migration file '%s' not found on filesystem, but its database record was removed.\n", migrationFileName)
 		} else {
-			log.Fatalf("Error removing migration file '%s' from filesystem (DB changes rolled back): %v\n", filePath, removeErr)
+			fatalf("Error removing migration file '%s' from filesystem (DB changes rolled back): %v\n", filePath, removeErr)
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		log.Fatalf("Error committing transaction: %v", err)
+		fatalf("Error committing transaction: %v", err)
 	}
+	setResult("removed", migrationFileName)
 
 	if removeErr == nil {
 		fmt.Printf("Successfully removed migration file '%s' and its database record.\n", migrationFileName)
@@ -931,6 +1615,8 @@ func runSQL(ctx context.Context, args []string) {
 	remote := cmd.String("remote", "", "remote turso url")
 	token := cmd.String("token", "", "turso auth token")
 	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
+	yes := cmd.Bool("yes-i-am-sure", false, "skip the confirmation for protected environments")
 	dir := cmd.String("dir", "migrations", "directory")
 	cmd.Parse(args)
 
@@ -945,22 +1631,22 @@ func runSQL(ctx context.Context, args []string) {
 	}
 
 	if query == "" {
-		log.Fatal("Usage: sql \"SELECT ...\" or sql filename.sql")
+		fatalf("Usage: sql \"SELECT ...\" or sql filename.sql")
 	}
 
 	schemaPath := filepath.Join(*rdir, "db.schema")
-	conn, dbtype, err := Conn2DB(schemaPath, *db, *url)
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
 	if err != nil {
-		log.Fatalf("Error connecting: %v", err)
+		fatalf("Error connecting: %v", err)
 	}
 
 	if dbtype == "tursosync" {
 		fmt.Println("🚀 Turso Sync detected: Routing SQL directly to the Remote Primary...")
 		conn.Close()
 
-		remoteConn, err := getTursoRemoteConn(schemaPath, *remote, *token)
+		remoteConn, err := getTursoRemoteConn(schemaPath, *env, *remote, *token)
 		if err != nil {
-			log.Fatalf("Failed to connect to Remote Primary: %v", err)
+			fatalf("Failed to connect to Remote Primary: %v", err)
 		}
 		conn = remoteConn
 	}
@@ -970,17 +1656,22 @@ func runSQL(ctx context.Context, args []string) {
 		fileP := filepath.Join(*rdir, *dir, query)
 		sqlFile, err := os.ReadFile(fileP)
 		if err != nil {
-			log.Fatalf("Error reading SQL file: %v\n", err)
+			fatalf("Error reading SQL file: %v\n", err)
+		}
+		if !migrate.IsReadOnly(string(sqlFile), dbtype) {
+			if err := confirmProtected(schemaPath, *env, *url, *yes, "run "+query); err != nil {
+				fatalf("Error: %v", err)
+			}
 		}
 		rows, err := conn.QueryContext(ctx, string(sqlFile))
 		if err != nil {
-			log.Fatalf("Error executing SQL query: %v\n", err)
+			fatalf("Error executing SQL query: %v\n", err)
 		}
 		defer rows.Close()
 
 		columns, err := rows.Columns()
 		if err != nil {
-			log.Fatalf("Error getting columns: %v\n", err)
+			fatalf("Error getting columns: %v\n", err)
 		}
 
 		var data [][]string
@@ -989,6 +1680,7 @@ func runSQL(ctx context.Context, args []string) {
 		for i := range values {
 			scanArgs[i] = &values[i]
 		}
+		jsonRows := [][]any{}
 		for rows.Next() {
 			rows.Scan(scanArgs...)
 			row := make([]string, len(columns))
@@ -1000,22 +1692,31 @@ func runSQL(ctx context.Context, args []string) {
 				}
 			}
 			data = append(data, row)
+			jsonRows = append(jsonRows, jsonRow(values))
 		}
+		setResult("columns", columns)
+		setResult("rows", jsonRows)
 		fmt.Println(printTable(columns, data))
 		fmt.Printf("SQL file executed successfully: %s\n", query)
 		return
 	}
 
+	if !migrate.IsReadOnly(query, dbtype) {
+		if err := confirmProtected(schemaPath, *env, *url, *yes, "run this statement"); err != nil {
+			fatalf("Error: %v", err)
+		}
+	}
+
 	upperQuery := strings.ToUpper(strings.TrimSpace(query))
 	if strings.HasPrefix(upperQuery, "SELECT") || strings.HasPrefix(upperQuery, "WITH") || strings.HasPrefix(upperQuery, "EXPLAIN") || strings.HasPrefix(upperQuery, "SHOW") || strings.HasPrefix(upperQuery, "PRAGMA") {
 		rows, err := conn.QueryContext(ctx, query)
 		if err != nil {
-			log.Fatalf("Error executing SQL query: %v\n", err)
+			fatalf("Error executing SQL query: %v\n", err)
 		}
 		defer rows.Close()
 		columns, err := rows.Columns()
 		if err != nil {
-			log.Fatalf("Error getting columns: %v\n", err)
+			fatalf("Error getting columns: %v\n", err)
 		}
 		var data [][]string
 		values := make([]any, len(columns))
@@ -1023,6 +1724,7 @@ func runSQL(ctx context.Context, args []string) {
 		for i := range values {
 			scanArgs[i] = &values[i]
 		}
+		jsonRows := [][]any{}
 		for rows.Next() {
 			rows.Scan(scanArgs...)
 			row := make([]string, len(columns))
@@ -1034,15 +1736,19 @@ func runSQL(ctx context.Context, args []string) {
 				}
 			}
 			data = append(data, row)
+			jsonRows = append(jsonRows, jsonRow(values))
 		}
+		setResult("columns", columns)
+		setResult("rows", jsonRows)
 		fmt.Println(printTable(columns, data))
 
 	} else {
 		result, err := conn.ExecContext(ctx, query)
 		if err != nil {
-			log.Fatalf("Error executing SQL command: %v\n", err)
+			fatalf("Error executing SQL command: %v\n", err)
 		}
 		rowsAffected, _ := result.RowsAffected()
+		setResult("rows_affected", rowsAffected)
 		fmt.Printf("SQL command executed successfully. Rows affected: %d\n", rowsAffected)
 	}
 }
@@ -1052,12 +1758,13 @@ func runLSP(args []string) {
 	db := cmd.String("db", "", "database type")
 	url := cmd.String("url", "", "database url")
 	rdir := cmd.String("rdir", "schema", "root directory")
+	env := cmd.String("env", "", "named environment from db.schema")
 	cmd.Parse(args)
 
 	schemaPath := filepath.Join(*rdir, "db.schema")
-	conn, dbtype, err := Conn2DB(schemaPath, *db, *url)
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		fatalf("Error connecting to database: %v", err)
 	}
 	defer conn.Close()
 
@@ -1092,34 +1799,50 @@ func runGenerate(ctx context.Context, args []string) {
 	db := cmd.String("db", "", "database type")
 	url := cmd.String("url", "", "connection url")
 	rdir := cmd.String("rdir", "schema", "root directory")
-	cmd.Parse(args)
-
-	var migrationName string
-	if len(cmd.Args()) > 0 {
-		migrationName = cmd.Args()[0]
+	env := cmd.String("env", "", "named environment from db.schema")
+	lockTimeout := cmd.Duration("lock-timeout", time.Minute, "how long to wait for another migration to finish")
+	timestamp := cmd.Bool("timestamp", false, "version the migration with a UTC timestamp instead of the next number")
+	shadow := cmd.Bool("shadow", false, "diff against the migrations replayed on a scratch database instead of the live one")
+	scratchURL := cmd.String("scratch-url", "", "empty database for -shadow (defaults to a temporary file for sqlite)")
+	var vars varFlags
+	cmd.Var(&vars, "var", "placeholder value as name=value, can be repeated")
+
+	migrationName := "auto_migration"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		migrationName = args[0]
+		cmd.Parse(args[1:])
 	} else {
-		migrationName = "auto_migration"
+		cmd.Parse(args)
+		if len(cmd.Args()) > 0 {
+			migrationName = cmd.Args()[0]
+		}
 	}
 
 	schemaPath := filepath.Join(*rdir, "db.schema")
 
-	conn, dbtype, err := Conn2DB(schemaPath, *db, *url)
+	conn, dbtype, err := Conn2DB(schemaPath, *env, *db, *url)
 	if err != nil {
-		log.Fatalf("Error connecting: %v", err)
+		fatalf("Error connecting: %v", err)
 	}
 	defer conn.Close()
 
-	currentSchema, err := InspectSchema(ctx, conn, dbtype)
-	if err != nil {
-		log.Fatalf("Error inspecting current database schema: %v", err)
+	m := newMigrator(conn, dbtype, *rdir, "migrations")
+	unlock := lockMigrations(ctx, m, *lockTimeout)
+	defer unlock()
+
+	var currentSchema *migrate.Database
+	if *shadow {
+		currentSchema = shadowSchema(ctx, m, schemaPath, *env, *scratchURL, vars)
+	} else if currentSchema, err = migrateConfig.InspectSchema(ctx, conn, dbtype); err != nil {
+		fatalf("Error inspecting current database schema: %v", err)
 	}
 
-	desiredSchema, err := ParseSchemaFile(schemaPath)
+	desiredSchema, err := migrate.ParseSchemaFile(schemaPath)
 	if err != nil {
-		log.Fatalf("Error parsing local schema file: %v", err)
+		fatalf("Error parsing local schema file: %v", err)
 	}
 
-	diff := DiffSchemas(currentSchema, desiredSchema)
+	diff := migrate.DiffSchemas(currentSchema, desiredSchema)
 	var fatalErrors []string
 	for _, tDiff := range diff.TablesToAlter {
 		for _, addCol := range tDiff.ColumnsToAdd {
@@ -1136,11 +1859,13 @@ func runGenerate(ctx context.Context, args []string) {
 			fmt.Printf("\033[31m- %s\033[0m\n", errStr)
 		}
 		fmt.Println("\033[33mFix: Provide a DEFAULT value in your schema, or make the column nullable.\033[0m")
-		os.Exit(1)
+		setResult("problems", fatalErrors)
+		fatalf("Error: %v", errUnsafeChange)
 	}
-	migrationSQL := GenerateMigrationSQL(diff, dbtype)
+	migrationSQL := migrate.GenerateMigrationSQL(diff, dbtype)
 
 	if strings.TrimSpace(migrationSQL) == "" {
+		setResult("file", nil)
 		fmt.Println("No schema changes detected. Everything is up to date!")
 		return
 	}
@@ -1176,6 +1901,7 @@ func runGenerate(ctx context.Context, args []string) {
 		response = strings.TrimSpace(strings.ToLower(response))
 
 		if response != "y" && response != "yes" {
+			setResult("file", nil)
 			fmt.Println("Migration aborted. No files were written.")
 			return
 		}
@@ -1213,55 +1939,58 @@ func runGenerate(ctx context.Context, args []string) {
 		}
 	}
 
-	rollbackSQL := GenerateMigrationSQL(DiffSchemas(desiredSchema, currentSchema), dbtype)
+	rollbackSQL := migrate.GenerateMigrationSQL(migrate.DiffSchemas(desiredSchema, currentSchema), dbtype)
 
 	dirPath := filepath.Join(*rdir, "migrations")
 	entries, _ := os.ReadDir(dirPath)
-	maxPrefix := -1
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
-			parts := strings.SplitN(entry.Name(), "_", 2)
-			if prefix, err := strconv.Atoi(parts[0]); err == nil && prefix > maxPrefix {
-				maxPrefix = prefix
-			}
+	useTimestamp := *timestamp
+	if !isFlagPassed(cmd, "timestamp") {
+		if useTimestamp, err = useTimestampVersions(schemaPath); err != nil {
+			fatalf("Error reading versioning config: %v\n", err)
 		}
 	}
 
-	fileName := fmt.Sprintf("%d_%s.sql", maxPrefix+1, migrationName)
+	fileName := fmt.Sprintf("%s_%s.sql", migrate.NextMigrationPrefix(entries, useTimestamp), migrationName)
 	filePath := filepath.Join(dirPath, fileName)
 	finalFileContent := fmt.Sprintf("%s\n\n-- schema rollback\n\n%s", migrationSQL, rollbackSQL)
+	// Postgres before 12 refuses ALTER TYPE ... ADD VALUE inside a transaction block.
+	if dbtype == "postgres" && strings.Contains(migrationSQL, " ADD VALUE ") {
+		finalFileContent = migrate.NoTransactionDirective + "\n\n" + finalFileContent
+	}
 
 	if err := os.WriteFile(filePath, []byte(finalFileContent), 0644); err != nil {
-		log.Fatalf("Failed to write migration file: %v", err)
+		fatalf("Failed to write migration file: %v", err)
 	}
 
-	dialect := GetDialect(dbtype)
+	dialect := migrateConfig.Dialect(dbtype)
 	if _, err := conn.ExecContext(ctx, dialect.Insert, fileName, false); err != nil {
-		log.Fatalf("Failed to track new migration: %v", err)
+		fatalf("Failed to track new migration: %v", err)
 	}
 
+	setResult("file", filePath)
+	setResult("dropped_tables", nonNil(droppedTables))
+	setResult("dropped_columns", nonNil(droppedColumns))
 	fmt.Printf("Successfully generated migration: %s\n", fileName)
 }
 
 // initTursoSync initializes the embedded replica for data pushing/pulling
-func initTursoSync(schemaPath, overrideURL, overrideRemote, overrideToken string) (*turso.TursoSyncDb, error) {
+func initTursoSync(schemaPath, env, overrideURL, overrideRemote, overrideToken string) (*turso.TursoSyncDb, error) {
 	var localUrl, remoteUrl, authToken string
 
 	// Parse from file if it exists
-	file, err := os.Open(schemaPath)
-	if err == nil {
-		defer file.Close()
-		envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if strings.HasPrefix(line, "url =") {
-				localUrl = extractConfigValue(line, envRegex)
-			} else if strings.HasPrefix(line, "remote_url =") {
-				remoteUrl = extractConfigValue(line, envRegex)
-			} else if strings.HasPrefix(line, "auth_token =") {
-				authToken = extractConfigValue(line, envRegex)
-			}
+	lines, err := readConfig(schemaPath, env)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
+	for _, l := range lines {
+		line := l.Text
+		if strings.HasPrefix(line, "url =") {
+			localUrl = extractConfigValue(line, envRegex)
+		} else if strings.HasPrefix(line, "remote_url =") {
+			remoteUrl = extractConfigValue(line, envRegex)
+		} else if strings.HasPrefix(line, "auth_token =") {
+			authToken = extractConfigValue(line, envRegex)
 		}
 	}
 
@@ -1289,21 +2018,20 @@ func initTursoSync(schemaPath, overrideURL, overrideRemote, overrideToken string
 }
 
 // getTursoRemoteConn provides a direct connection to the remote primary server for DDL/Schema changes
-func getTursoRemoteConn(schemaPath, overrideRemote, overrideToken string) (*sql.DB, error) {
+func getTursoRemoteConn(schemaPath, env, overrideRemote, overrideToken string) (*sql.DB, error) {
 	var remoteUrl, authToken string
 
-	file, err := os.Open(schemaPath)
-	if err == nil {
-		defer file.Close()
-		envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if strings.HasPrefix(line, "remote_url =") {
-				remoteUrl = extractConfigValue(line, envRegex)
-			} else if strings.HasPrefix(line, "auth_token =") {
-				authToken = extractConfigValue(line, envRegex)
-			}
+	lines, err := readConfig(schemaPath, env)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
+	for _, l := range lines {
+		line := l.Text
+		if strings.HasPrefix(line, "remote_url =") {
+			remoteUrl = extractConfigValue(line, envRegex)
+		} else if strings.HasPrefix(line, "auth_token =") {
+			authToken = extractConfigValue(line, envRegex)
 		}
 	}
 
@@ -1325,6 +2053,238 @@ func getTursoRemoteConn(schemaPath, overrideRemote, overrideToken string) (*sql.
 	return sql.Open("libsql", connStr)
 }
 
+// errProtected is wrapped by the errors of confirmProtected.
+var errProtected = errors.New("protected environment")
+
+// errUnsafeChange is reported when generate refuses a schema change that would fail on existing rows.
+var errUnsafeChange = errors.New("migration generation aborted, the schema adds NOT NULL columns without a DEFAULT")
+
+// protectedTarget reports whether the database picked by env and overrideURL is protected, by
+// `protected = true` in its env block or a `protect_url = "pattern"` line matching its url or
+// remote_url. name is what the user types to confirm: the environment, or else the url's host.
+func protectedTarget(schemaPath, env, overrideURL string) (name string, protected bool, err error) {
+	lines, err := readConfig(schemaPath, env)
+	if err != nil && !os.IsNotExist(err) {
+		return "", false, err
+	}
+
+	envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
+	var dbURL, remoteURL string
+	var patterns []string
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l.Text, "url ="):
+			dbURL = extractConfigValue(l.Text, envRegex)
+		case strings.HasPrefix(l.Text, "remote_url ="):
+			remoteURL = extractConfigValue(l.Text, envRegex)
+		case strings.HasPrefix(l.Text, "protected ="):
+			protected = extractConfigValue(l.Text, envRegex) == "true"
+		case strings.HasPrefix(l.Text, "protect_url ="):
+			patterns = append(patterns, extractConfigValue(l.Text, envRegex))
+		}
+	}
+	if overrideURL != "" {
+		dbURL = overrideURL
+	}
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+		if err != nil {
+			return "", false, fmt.Errorf("invalid protect_url '%s': %w", pattern, err)
+		}
+		if (dbURL != "" && re.MatchString(dbURL)) || (remoteURL != "" && re.MatchString(remoteURL)) {
+			protected = true
+		}
+	}
+
+	name = env
+	if name == "" {
+		name = dbURL
+		if u, err := neturl.Parse(dbURL); err == nil && u.Hostname() != "" {
+			name = u.Hostname()
+		}
+	}
+	return name, protected, nil
+}
+
+// confirmProtected asks the user to type the name of a protected target before action runs
+// against it. It returns an error when the answer doesn't match, or in a non-interactive shell,
+// unless yes (--yes-i-am-sure) is set.
+func confirmProtected(schemaPath, env, overrideURL string, yes bool, action string) error {
+	name, protected, err := protectedTarget(schemaPath, env, overrideURL)
+	if err != nil {
+		return err
+	}
+	if !protected || yes {
+		return nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("refusing to %s in a non-interactive shell without --yes-i-am-sure, %s is a %w", action, name, errProtected)
+	}
+	fmt.Printf("\033[31m%s is a protected environment.\033[0m Type %s to %s: ", name, name, action)
+	response, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(response) != name {
+		return fmt.Errorf("confirmation did not match, refusing to %s, %s is a %w", action, name, errProtected)
+	}
+	return nil
+}
+
+// configLine is a trimmed line of db.schema and its line number.
+type configLine struct {
+	Text string
+	Num  int
+}
+
+var envBlockRegex = regexp.MustCompile(`^env\s+([A-Za-z0-9_-]+)\s*\{$`)
+
+// readConfig returns the lines of db.schema that apply to the named environment: every line
+// outside `env <name> { ... }` blocks, followed by the lines of that environment's block so its
+// settings win. An empty name skips every env block.
+func readConfig(schemaPath, env string) ([]configLine, error) {
+	file, err := os.Open(schemaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var shared, selected []configLine
+	block, found := "", false
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if block != "" {
+			if line == "}" {
+				block = ""
+			} else if block == env {
+				selected = append(selected, configLine{line, lineNumber})
+			}
+			continue
+		}
+		if matches := envBlockRegex.FindStringSubmatch(line); matches != nil {
+			block = matches[1]
+			found = found || block == env
+			continue
+		}
+		shared = append(shared, configLine{line, lineNumber})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if env != "" && !found {
+		return nil, fmt.Errorf("environment '%s' is not defined", env)
+	}
+	return append(shared, selected...), nil
+}
+
+// varFlags collects repeated -var name=value flags.
+type varFlags []string
+
+func (v *varFlags) String() string { return strings.Join(*v, ",") }
+
+func (v *varFlags) Set(value string) error {
+	if name, _, ok := strings.Cut(value, "="); !ok || name == "" {
+		return fmt.Errorf("expected name=value, got '%s'", value)
+	}
+	*v = append(*v, value)
+	return nil
+}
+
+// migrationVars returns the placeholder values for migration files: the `var name = "value"`
+// lines of db.schema and of the env block, overridden by -var flags.
+func migrationVars(schemaPath, env string, flags varFlags) (map[string]string, error) {
+	vars := make(map[string]string)
+	// Headless runs pass -db and -url without a db.schema, which has no vars then.
+	lines, err := readConfig(schemaPath, env)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
+	for _, l := range lines {
+		if rest, ok := strings.CutPrefix(l.Text, "var "); ok {
+			name, value, _ := strings.Cut(rest, "=")
+			// An env("NAME") value whose variable is unset stays unresolved.
+			if matches := envRegex.FindStringSubmatch(value); len(matches) == 2 {
+				if _, ok := os.LookupEnv(matches[1]); !ok {
+					continue
+				}
+			}
+			vars[strings.TrimSpace(name)] = extractConfigValue(rest, envRegex)
+		}
+	}
+
+	for _, v := range flags {
+		name, value, _ := strings.Cut(v, "=")
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// useTimestampVersions reports whether db.schema sets versioning = "timestamp".
+func useTimestampVersions(schemaPath string) (bool, error) {
+	file, err := os.Open(schemaPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "versioning =") {
+			switch value := extractConfigValue(line, envRegex); value {
+			case "timestamp":
+				return true, nil
+			case "numeric", "":
+				return false, nil
+			default:
+				return false, fmt.Errorf("unknown versioning '%s' in %s, expected \"numeric\" or \"timestamp\"", value, schemaPath)
+			}
+		}
+	}
+	return false, scanner.Err()
+}
+
+// migrateConfig holds the tracking tables and schemas of db.schema, set by configureMigrate.
+var migrateConfig migrate.Config
+
+// configureMigrate reads the settings of db.schema that the migrate package uses into
+// migrateConfig: the migrations_table, migrations_schema and lock_table names, and the Postgres
+// schemas to manage.
+func configureMigrate(lines []configLine, dbType string) error {
+	envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
+	var cfg migrate.Config
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l.Text, "schemas ="):
+			cfg.Schemas = nil
+			for name := range strings.SplitSeq(extractConfigValue(l.Text, envRegex), ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					cfg.Schemas = append(cfg.Schemas, name)
+				}
+			}
+		case strings.HasPrefix(l.Text, "migrations_table ="):
+			cfg.MigrationsTable = extractConfigValue(l.Text, envRegex)
+		case strings.HasPrefix(l.Text, "migrations_schema ="):
+			cfg.MigrationsSchema = extractConfigValue(l.Text, envRegex)
+		case strings.HasPrefix(l.Text, "lock_table ="):
+			cfg.LockTable = extractConfigValue(l.Text, envRegex)
+		}
+	}
+	if err := cfg.Validate(dbType); err != nil {
+		return err
+	}
+	migrateConfig = cfg
+	return nil
+}
+
 func extractConfigValue(line string, envRegex *regexp.Regexp) string {
 	parts := strings.SplitN(line, "=", 2)
 	if len(parts) == 2 {
@@ -1339,65 +2299,86 @@ func extractConfigValue(line string, envRegex *regexp.Regexp) string {
 }
 
 func CheckTableExists(ctx context.Context, conn *sql.DB, dbtype string, rdir string) {
-	dialect := GetDialect(dbtype)
+	m := newMigrator(conn, dbtype, rdir, "migrations")
+	if !writeInitFile(ctx, conn, dbtype, rdir) {
+		if _, err := m.EnsureTrackingTable(ctx); err != nil {
+			fatalf("Error upgrading %s table: %v\n", migrateConfig.TrackingTable(), err)
+		}
+		return
+	}
+
+	if _, err := m.EnsureTrackingTable(ctx); err != nil {
+		fatalf("Error creating %s table: %v\n", migrateConfig.TrackingTable(), err)
+	}
+	if err := PullDBSchema(ctx, conn, dbtype, filepath.Join(rdir, "db.schema")); err != nil {
+		fatalf("Migrate2: Err pulling schema %v\n", err)
+	}
+	fmt.Println("Schema DB successfully initialized")
+}
+
+// writeInitFile reports whether the database has no tracking table yet, and if so writes
+// rdir/migrations/0_init.sql, which creates it, unless the file already exists.
+func writeInitFile(ctx context.Context, conn *sql.DB, dbtype string, rdir string) bool {
+	dialect := migrateConfig.Dialect(dbtype)
 	if dialect.Type == "" {
-		log.Fatalf("Unsupported database type for table existence check: %s", dbtype)
+		fatalf("Unsupported database type for table existence check: %s", dbtype)
 	}
 
 	var name string
 	err := conn.QueryRowContext(ctx, dialect.TableExists).Scan(&name)
+	if err != nil && err != sql.ErrNoRows {
+		fatalf("Error querying table existence: %v\n", err)
+	}
+	if err == nil {
+		return false
+	}
 
-	if err == sql.ErrNoRows {
-		migrationsDir := filepath.Join(rdir, "migrations")
-		if _, dirErr := os.Stat(migrationsDir); os.IsNotExist(dirErr) {
-			err = os.MkdirAll(migrationsDir, 0700)
-			if err != nil {
-				log.Fatalf("Error creating migrations directory: %v\n", err)
-			}
-		}
-
-		initFilePath := filepath.Join(rdir, "migrations", "0_init.sql")
-		if _, fileErr := os.Stat(initFilePath); os.IsNotExist(fileErr) {
-			file, err := os.Create(initFilePath)
-			if err != nil {
-				log.Fatalf("Error creating 0_init.sql file: %v\n", err)
-			}
-			defer file.Close()
-
-			if dbtype == "sqlite" {
-				_, _ = file.WriteString("PRAGMA journal_mode=WAL;\n\n")
-			}
-			_, err = file.WriteString(dialect.CreateInit)
-			if err != nil {
-				log.Fatalf("Error writing to 0_init.sql file: %v\n", err)
-			}
-		}
-
-		sqlFile, err := os.ReadFile(initFilePath)
+	migrationsDir := filepath.Join(rdir, "migrations")
+	if _, dirErr := os.Stat(migrationsDir); os.IsNotExist(dirErr) {
+		err = os.MkdirAll(migrationsDir, 0700)
 		if err != nil {
-			log.Fatalf("Error reading SQL file: %v\n", err)
+			fatalf("Error creating migrations directory: %v\n", err)
 		}
+	}
 
-		_, err = conn.ExecContext(ctx, string(sqlFile))
+	initFilePath := filepath.Join(rdir, "migrations", "0_init.sql")
+	if _, fileErr := os.Stat(initFilePath); os.IsNotExist(fileErr) {
+		file, err := os.Create(initFilePath)
 		if err != nil {
-			log.Fatalf("Error executing SQL to create _schema_migrations table: %v\n", err)
+			fatalf("Error creating 0_init.sql file: %v\n", err)
 		}
+		defer file.Close()
 
-		_, err = conn.ExecContext(ctx, dialect.Insert, "0_init.sql", true)
-		if err != nil {
-			log.Fatalf("Error executing SQL to insert 0_init.sql record: %v\n", err)
+		if dbtype == "sqlite" {
+			_, _ = file.WriteString("PRAGMA journal_mode=WAL;\n\n")
 		}
-
-		err = PullDBSchema(ctx, conn, dbtype, filepath.Join(rdir, "db.schema"))
+		_, err = file.WriteString(dialect.CreateInit)
 		if err != nil {
-			log.Fatalf("Migrate2: Err pulling schema %v\n", err)
+			fatalf("Error writing to 0_init.sql file: %v\n", err)
 		}
+	}
+	return true
+}
 
-		fmt.Println("Schema DB successfully initialized")
-		return
-	} else if err != nil {
-		log.Fatalf("Error querying table existence: %v\n", err)
+// exitHooks run before a fatal exit so that held migration locks are released.
+var exitHooks []func()
+
+func fatalf(format string, v ...any) {
+	log.Printf(format, v...)
+	if jsonOutput {
+		var err error
+		for _, arg := range v {
+			if e, ok := arg.(error); ok {
+				err = e
+				break
+			}
+		}
+		writeResult(currentCommand(), exitFailure, errorObject(strings.TrimSpace(fmt.Sprintf(format, v...)), err))
+	}
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
 	}
+	os.Exit(exitFailure)
 }
 
 func isFlagPassed(fs *flag.FlagSet, name string) bool {
@@ -1410,22 +2391,23 @@ func isFlagPassed(fs *flag.FlagSet, name string) bool {
 	return found
 }
 
-func Conn2DB(schemaFilePath, overrideDB, overrideURL string) (*sql.DB, string, error) {
+func Conn2DB(schemaFilePath, env, overrideDB, overrideURL string) (*sql.DB, string, error) {
 	if overrideDB != "" && overrideURL != "" {
-		var driverName string
-		switch overrideDB {
-		case "sqlite":
-			driverName = "sqlite"
-		case "postgres":
-			driverName = "pgx"
-		case "mysql", "mariadb":
-			driverName = "mysql"
-		case "libsql", "tursosync":
-			driverName = "libsql"
-		case "turso":
-			driverName = "turso"
-		default:
-			return nil, "", fmt.Errorf("unsupported database type '%s'", overrideDB)
+		driverName, err := migrate.DriverName(overrideDB)
+		if err != nil {
+			return nil, "", err
+		}
+		// The connection doesn't need .env here, but env() settings and ${env:NAME} placeholders
+		// should resolve the same as without -db and -url.
+		if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("error loading .env file: %w", err)
+		}
+		lines, err := readConfig(schemaFilePath, env)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("error reading schema file '%s': %w", schemaFilePath, err)
+		}
+		if err := configureMigrate(lines, overrideDB); err != nil {
+			return nil, "", fmt.Errorf("%w in schema '%s'", err, schemaFilePath)
 		}
 		conn, err := sql.Open(driverName, overrideURL)
 		if err != nil {
@@ -1439,23 +2421,24 @@ func Conn2DB(schemaFilePath, overrideDB, overrideURL string) (*sql.DB, string, e
 		return nil, "", fmt.Errorf("error loading .env file: %w", err)
 	}
 
-	file, err := os.Open(schemaFilePath)
+	lines, err := readConfig(schemaFilePath, env)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to open schema file '%s': %w", schemaFilePath, err)
+		if os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("failed to open schema file '%s': %w", schemaFilePath, err)
+		}
+		return nil, "", fmt.Errorf("error reading schema file '%s': %w", schemaFilePath, err)
 	}
-	defer file.Close()
 
 	var dbType, dbURL string
 	foundDbType := false
-	lineNumber := 0
+	urlLine := 0
 	dbTypePrefix := "db ="
 	dbURLPrefix := "url ="
 	envRegex := regexp.MustCompile(`env\("([^"]+)"\)`)
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lineNumber++
-		line := strings.TrimSpace(scanner.Text())
+	// Later lines win, so an env block overrides the shared settings.
+	for _, l := range lines {
+		line, lineNumber := l.Text, l.Num
 
 		if strings.HasPrefix(line, dbTypePrefix) {
 			parts := strings.SplitN(line, "=", 2)
@@ -1470,17 +2453,8 @@ func Conn2DB(schemaFilePath, overrideDB, overrideURL string) (*sql.DB, string, e
 		if strings.HasPrefix(line, dbURLPrefix) {
 			parts := strings.SplitN(line, "=", 2)
 			if len(parts) == 2 {
-				value := strings.Trim(strings.TrimSpace(parts[1]), "\"'")
-				matches := envRegex.FindStringSubmatch(value)
-				if len(matches) == 2 {
-					envVarName := matches[1]
-					dbURL = os.Getenv(envVarName)
-					if dbURL == "" {
-						fmt.Printf("Warning: Environment variable '%s' not found in .env (referenced in '%s' on line %d)\n", envVarName, schemaFilePath, lineNumber)
-					}
-				} else {
-					dbURL = value
-				}
+				dbURL = strings.Trim(strings.TrimSpace(parts[1]), "\"'")
+				urlLine = lineNumber
 			} else {
 				fmt.Printf("Warning: Invalid '%s' format in schema '%s' on line %d: %s\n", dbURLPrefix, schemaFilePath, lineNumber, line)
 			}
@@ -1495,8 +2469,12 @@ func Conn2DB(schemaFilePath, overrideDB, overrideURL string) (*sql.DB, string, e
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, "", fmt.Errorf("error reading schema file '%s': %w", schemaFilePath, err)
+	if matches := envRegex.FindStringSubmatch(dbURL); len(matches) == 2 {
+		envVarName := matches[1]
+		dbURL = os.Getenv(envVarName)
+		if dbURL == "" {
+			fmt.Printf("Warning: Environment variable '%s' not found in .env (referenced in '%s' on line %d)\n", envVarName, schemaFilePath, urlLine)
+		}
 	}
 
 	if !foundDbType {
@@ -1507,20 +2485,12 @@ func Conn2DB(schemaFilePath, overrideDB, overrideURL string) (*sql.DB, string, e
 		return nil, "", fmt.Errorf("could not determine database URL in schema file '%s'", schemaFilePath)
 	}
 
-	var driverName string
-	switch dbType {
-	case "sqlite":
-		driverName = "sqlite"
-	case "postgres":
-		driverName = "pgx"
-	case "mysql", "mariadb":
-		driverName = "mysql"
-	case "libsql", "tursosync":
-		driverName = "libsql"
-	case "turso":
-		driverName = "turso"
-	default:
-		return nil, "", fmt.Errorf("unsupported database type '%s' in schema '%s'", dbType, schemaFilePath)
+	driverName, err := migrate.DriverName(dbType)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w in schema '%s'", err, schemaFilePath)
+	}
+	if err := configureMigrate(lines, dbType); err != nil {
+		return nil, "", fmt.Errorf("%w in schema '%s'", err, schemaFilePath)
 	}
 	conn, err := sql.Open(driverName, dbURL)
 	if err != nil {
@@ -1530,7 +2500,7 @@ func Conn2DB(schemaFilePath, overrideDB, overrideURL string) (*sql.DB, string, e
 }
 
 func PullDBSchema(ctx context.Context, conn *sql.DB, dbtype, schemaFilePath string) error {
-	dbSchema, err := InspectSchema(ctx, conn, dbtype)
+	dbSchema, err := migrateConfig.InspectSchema(ctx, conn, dbtype)
 	if err != nil {
 		return fmt.Errorf("error inspecting schema: %w", err)
 	}
@@ -1556,9 +2526,12 @@ func PullDBSchema(ctx context.Context, conn *sql.DB, dbtype, schemaFilePath stri
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, "db") || strings.HasPrefix(line, "url") || !foundSchemaStart {
+			// Settings such as migrations_table contain "table " too, so only a line starting with
+			// a table or enum declaration ends the config.
+			trimmed := strings.TrimSpace(line)
 			if !strings.Contains(line, "CREATE TABLE") &&
-				!strings.Contains(line, "table ") &&
-				!strings.Contains(line, "enum ") &&
+				!strings.HasPrefix(trimmed, "table ") &&
+				!strings.HasPrefix(trimmed, "enum ") &&
 				!strings.Contains(line, "PRIMARY KEY") {
 				configLines = append(configLines, line)
 			} else {
@@ -1572,8 +2545,8 @@ func PullDBSchema(ctx context.Context, conn *sql.DB, dbtype, schemaFilePath stri
 
 	var cleanConfigLines []string
 	for _, line := range configLines {
-		if trimmed := strings.TrimSpace(line); trimmed != "" {
-			cleanConfigLines = append(cleanConfigLines, trimmed)
+		if strings.TrimSpace(line) != "" {
+			cleanConfigLines = append(cleanConfigLines, strings.TrimRight(line, " \t\r"))
 		}
 	}
 
@@ -1597,38 +2570,34 @@ func PullDBSchema(ctx context.Context, conn *sql.DB, dbtype, schemaFilePath stri
 	return nil
 }
 
-func generateSchemaString(db *Database) string {
+func generateSchemaString(db *migrate.Database) string {
 	var sections []string
 
 	for i := len(db.Tables) - 1; i >= 0; i-- {
 		t := db.Tables[i]
 
-		if t.Name == "_schema_migrations" ||
-			strings.HasPrefix(t.Name, "sqlite_") ||
-			strings.HasPrefix(t.Name, "turso_cdc") ||
-			strings.HasPrefix(t.Name, "turso_sync") ||
-			strings.HasPrefix(t.Name, "libsql_") {
+		if migrateConfig.IsInternalTable(t.Name) {
 			continue
 		}
 
 		pks := make(map[string]bool)
-		fks := make(map[string]Constraint)
+		fks := make(map[string]migrate.Constraint)
 		uniques := make(map[string]bool)
-		var checks []Constraint
+		var checks []migrate.Constraint
 
 		for _, c := range t.Constraints {
-			if len(c.Columns) == 1 && c.Kind != Check {
+			if len(c.Columns) == 1 && c.Kind != migrate.Check {
 				colName := c.Columns[0]
 				switch c.Kind {
-				case PrimaryKey:
+				case migrate.PrimaryKey:
 					pks[colName] = true
-				case ForeignKey:
+				case migrate.ForeignKey:
 					fks[colName] = c
-				case Unique:
+				case migrate.Unique:
 					uniques[colName] = true
 				}
 			}
-			if c.Kind == Check {
+			if c.Kind == migrate.Check {
 				checks = append(checks, c)
 			}
 		}
@@ -1889,12 +2858,14 @@ type model struct {
 	detailCursor       int
 	editingCell        bool
 	editInput          textinput.Model
+	// readOnly blocks cell edits and writing queries on protected environments, and says why.
+	readOnly string
 }
 
 func initialModel(db *sql.DB, dbType string) model {
 	tables, err := getSQLTables(db, dbType)
 	if err != nil {
-		log.Fatalf("Error getting SQL tables: %v", err)
+		fatalf("Error getting SQL tables: %v", err)
 	}
 
 	items := make([]list.Item, len(tables))
@@ -2053,7 +3024,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					pkCol := m.primaryKeyCol
 					pkVal := m.selectedRow[m.primaryKeyIdx]
 
-					safeTable := quoteID(m.selectedTable, m.dbType)
+					safeTable := quoteTable(m.selectedTable, m.dbType)
 					safeCol := quoteID(colName, m.dbType)
 					safePk := quoteID(pkCol, m.dbType)
 
@@ -2120,6 +3091,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 
 				case key.Matches(msg, m.keys.Edit):
+					if m.readOnly != "" {
+						m.viewport.SetContent(errorStyle.Render(m.readOnly + "\n\nPress Esc to return."))
+						return m, nil
+					}
 					// Open the editor!
 					m.editingCell = true
 					m.editInput.SetValue(m.selectedRow[m.detailCursor])
@@ -2300,7 +3275,7 @@ func (m *model) loadTableData(tableName string) error {
 	m.viewport.SetXOffset(0)
 	m.table.SetRows(nil)
 
-	rows, err := m.db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 50;", tableName))
+	rows, err := m.db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 50;", quoteTable(tableName, m.dbType)))
 	if err != nil {
 		return fmt.Errorf("failed to query data: %w", err)
 	}
@@ -2361,12 +3336,12 @@ func (m *model) loadTableData(tableName string) error {
 
 	m.primaryKeyCol = columns[0]
 	m.primaryKeyIdx = 0
-	dbSchema, err := InspectSchema(context.Background(), m.db, m.dbType)
+	dbSchema, err := migrateConfig.InspectSchema(context.Background(), m.db, m.dbType)
 	if err == nil {
 		for _, t := range dbSchema.Tables {
 			if t.Name == tableName {
 				for _, c := range t.Constraints {
-					if c.Kind == PrimaryKey && len(c.Columns) > 0 {
+					if c.Kind == migrate.PrimaryKey && len(c.Columns) > 0 {
 						m.primaryKeyCol = c.Columns[0]
 						for i, col := range columns {
 							if col == m.primaryKeyCol {
@@ -2402,6 +3377,12 @@ func (m *model) executeSQLQuery(query string) {
 	m.viewport.SetXOffset(0)
 	m.table.SetRows(nil)
 
+	if m.readOnly != "" && !migrate.IsReadOnly(query, m.dbType) {
+		m.queryError = errors.New(m.readOnly)
+		m.viewport.SetContent(errorStyle.Render(m.readOnly))
+		return
+	}
+
 	rows, err := m.db.Query(query)
 	if err != nil {
 		m.queryError = err
@@ -2486,7 +3467,7 @@ func (m *model) executeSQLQuery(query string) {
 }
 
 func getSQLTables(db *sql.DB, dbType string) ([]string, error) {
-	dialect := GetDialect(dbType)
+	dialect := migrateConfig.Dialect(dbType)
 	if dialect.ListTables == "" {
 		return nil, fmt.Errorf("unsupported database type for listing tables: %s", dbType)
 	}
@@ -2516,3 +3497,12 @@ func quoteID(id string, dbType string) string {
 	}
 	return "\"" + id + "\""
 }
+
+// quoteTable quotes a table name as listed by getSQLTables, where Postgres tables outside public
+// are named schema.table, so the schema and the table are quoted separately.
+func quoteTable(name string, dbType string) string {
+	if schema, table, ok := strings.Cut(name, "."); ok && dbType == "postgres" {
+		return quoteID(schema, dbType) + "." + quoteID(table, dbType)
+	}
+	return quoteID(name, dbType)
+}
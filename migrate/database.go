@@ -1,4 +1,4 @@
-package main
+package migrate
 
 import (
 	"context"
@@ -8,45 +8,174 @@ import (
 	"strings"
 )
 
-type Dialect struct{ Type, TableExists, CreateInit, Insert, Update, Delete, SelectStatus, ListTables, ListCols string }
+type Dialect struct{ Type, TableExists, CreateInit, Insert, Update, MarkApplied, MarkRolledBack, UpdateChecksum, Delete, SelectStatus, SelectOutOfOrder, SelectID, UpdateID, ListTables, ListCols, ListTrackingCols string }
 
+// DefaultMigrationsTable and DefaultLockTable are the tables the migrator keeps its state in,
+// unless a Config names others.
+const (
+	DefaultMigrationsTable = "_schema_migrations"
+	DefaultLockTable       = "_schema_lock"
+)
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Config names the tables a Migrator keeps its state in and the Postgres schemas it manages.
+// Empty fields keep the defaults.
+type Config struct {
+	// MigrationsSchema is only supported on Postgres, where it is created together with the
+	// migrations table.
+	MigrationsSchema string
+	MigrationsTable  string
+	// LockTable is only used by SQLite and libSQL.
+	LockTable string
+	// Schemas are the Postgres schemas InspectSchema reads and the studio lists, only public by
+	// default. Tables and enums outside public are named schema.name. Other databases only
+	// support the default.
+	Schemas []string
+}
+
+// withDefaults fills in the fields c leaves empty.
+func (c Config) withDefaults() Config {
+	if c.MigrationsTable == "" {
+		c.MigrationsTable = DefaultMigrationsTable
+	}
+	if c.LockTable == "" {
+		c.LockTable = DefaultLockTable
+	}
+	if len(c.Schemas) == 0 {
+		c.Schemas = []string{"public"}
+	}
+	return c
+}
+
+// Validate returns an error if c has invalid names or settings that dbType doesn't support.
+func (c Config) Validate(dbType string) error {
+	c = c.withDefaults()
+	for _, name := range append([]string{c.MigrationsSchema, c.MigrationsTable, c.LockTable}, c.Schemas...) {
+		if name != "" && !identifierRegex.MatchString(name) {
+			return fmt.Errorf("invalid name '%s', use letters, digits and underscores", name)
+		}
+	}
+	if c.MigrationsTable == c.LockTable {
+		return fmt.Errorf("the migrations and lock tables can't both be named '%s'", c.MigrationsTable)
+	}
+	if c.MigrationsSchema != "" && dbType != "postgres" {
+		return fmt.Errorf("%w for a migrations schema: %s", ErrUnsupportedDB, dbType)
+	}
+	if dbType != "postgres" && (len(c.Schemas) > 1 || c.Schemas[0] != "public") {
+		return fmt.Errorf("%w for schemas: %s", ErrUnsupportedDB, dbType)
+	}
+	return nil
+}
+
+// TrackingTable returns the table that tracks migrations, qualified with its schema if it has one.
+func (c Config) TrackingTable() string {
+	c = c.withDefaults()
+	if c.MigrationsSchema != "" {
+		return c.MigrationsSchema + "." + c.MigrationsTable
+	}
+	return c.MigrationsTable
+}
+
+// qualifiedName names a Postgres table or type by itself in public and as schema.name elsewhere.
+func qualifiedName(schema, name string) string {
+	if schema == "" || schema == "public" {
+		return name
+	}
+	return schema + "." + name
+}
+
+// splitQualified splits a schema.name into its parts, with public as the default schema.
+func splitQualified(name string) (schema, table string) {
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		return schema, table
+	}
+	return "public", name
+}
+
+// pgSchemaList returns the managed schemas as a list of SQL literals for an IN clause.
+func (c Config) pgSchemaList() string {
+	schemas := c.withDefaults().Schemas
+	quoted := make([]string, len(schemas))
+	for i, schema := range schemas {
+		quoted[i] = "'" + schema + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// GetDialect returns the Dialect of dbType for the default tables.
 func GetDialect(dbType string) Dialect {
+	return Config{}.Dialect(dbType)
+}
+
+// Dialect returns the statements dbType needs to track migrations in the tables of c.
+func (c Config) Dialect(dbType string) Dialect {
+	c = c.withDefaults()
+	t := c.TrackingTable()
 	switch dbType {
 	case "sqlite", "libsql", "turso", "tursosync":
 		return Dialect{
-			Type:         dbType,
-			TableExists:  "SELECT name FROM sqlite_master WHERE type='table' AND name='_schema_migrations'",
-			CreateInit:   "CREATE TABLE IF NOT EXISTS _schema_migrations (\n  id INTEGER PRIMARY KEY AUTOINCREMENT, \n  file VARCHAR(255) UNIQUE,\n  migrated BOOLEAN DEFAULT false\n);",
-			Insert:       "INSERT INTO _schema_migrations (file, migrated) VALUES (?, ?)",
-			Update:       "UPDATE _schema_migrations SET migrated = ? WHERE file = ?",
-			Delete:       "DELETE FROM _schema_migrations WHERE file = ?",
-			SelectStatus: "SELECT migrated FROM _schema_migrations WHERE file = ?",
-			ListTables:   "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE 'turso_cdc%' AND name NOT LIKE 'turso_sync%' AND name NOT LIKE 'libsql_%' AND name != '_schema_migrations';",
-			ListCols:     "SELECT name FROM PRAGMA_TABLE_INFO(?);",
+			Type:             dbType,
+			TableExists:      fmt.Sprintf("SELECT name FROM sqlite_master WHERE type='table' AND name='%s'", c.MigrationsTable),
+			CreateInit:       fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  id INTEGER PRIMARY KEY AUTOINCREMENT, \n  file VARCHAR(255) UNIQUE,\n  migrated BOOLEAN DEFAULT false,\n  checksum VARCHAR(64),\n  applied_at TIMESTAMP,\n  rolled_back_at TIMESTAMP,\n  execution_ms BIGINT,\n  version VARCHAR(64),\n  applied_by VARCHAR(255),\n  out_of_order BOOLEAN DEFAULT false,\n  rolled_back_by VARCHAR(255),\n  rollback_ms BIGINT\n);", t),
+			Insert:           fmt.Sprintf("INSERT INTO %s (file, migrated) VALUES (?, ?)", t),
+			Update:           fmt.Sprintf("UPDATE %s SET migrated = ? WHERE file = ?", t),
+			MarkApplied:      fmt.Sprintf("UPDATE %s SET migrated = true, checksum = ?, applied_at = CURRENT_TIMESTAMP, rolled_back_at = NULL, execution_ms = ?, version = ?, applied_by = ?, out_of_order = ? WHERE file = ?", t),
+			MarkRolledBack:   fmt.Sprintf("UPDATE %s SET migrated = false, rolled_back_at = CURRENT_TIMESTAMP, rollback_ms = ?, rolled_back_by = ? WHERE file = ?", t),
+			UpdateChecksum:   fmt.Sprintf("UPDATE %s SET checksum = ? WHERE file = ?", t),
+			Delete:           fmt.Sprintf("DELETE FROM %s WHERE file = ?", t),
+			SelectStatus:     fmt.Sprintf("SELECT migrated FROM %s WHERE file = ?", t),
+			SelectOutOfOrder: fmt.Sprintf("SELECT out_of_order FROM %s WHERE file = ?", t),
+			SelectID:         fmt.Sprintf("SELECT id FROM %s WHERE file = ?", t),
+			UpdateID:         fmt.Sprintf("UPDATE %s SET id = ? WHERE file = ?", t),
+			ListTables:       fmt.Sprintf("SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%%' AND name NOT LIKE 'turso_cdc%%' AND name NOT LIKE 'turso_sync%%' AND name NOT LIKE 'libsql_%%' AND name != '%s' AND name != '%s';", c.MigrationsTable, c.LockTable),
+			ListCols:         "SELECT name FROM PRAGMA_TABLE_INFO(?);",
+			ListTrackingCols: fmt.Sprintf("SELECT name FROM PRAGMA_TABLE_INFO('%s');", c.MigrationsTable),
 		}
 	case "postgres":
+		schema := c.MigrationsSchema
+		createSchema := ""
+		if schema == "" {
+			schema = "public"
+		} else {
+			createSchema = fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;\n", schema)
+		}
 		return Dialect{
-			Type:         dbType,
-			TableExists:  "SELECT tablename FROM pg_tables WHERE schemaname = 'public' AND tablename = '_schema_migrations'",
-			CreateInit:   "CREATE TABLE IF NOT EXISTS _schema_migrations (\n  id SERIAL PRIMARY KEY, \n  file VARCHAR(255) UNIQUE,\n  migrated BOOLEAN DEFAULT false\n);",
-			Insert:       "INSERT INTO _schema_migrations (file, migrated) VALUES ($1, $2)",
-			Update:       "UPDATE _schema_migrations SET migrated = $1 WHERE file = $2",
-			Delete:       "DELETE FROM _schema_migrations WHERE file = $1",
-			SelectStatus: "SELECT migrated FROM _schema_migrations WHERE file = $1",
-			ListTables:   "SELECT tablename FROM pg_tables WHERE schemaname = 'public';",
-			ListCols:     "SELECT column_name FROM information_schema.columns WHERE table_schema = 'public' AND table_name = $1 ORDER BY ordinal_position;",
+			Type:             dbType,
+			TableExists:      fmt.Sprintf("SELECT tablename FROM pg_tables WHERE schemaname = '%s' AND tablename = '%s'", schema, c.MigrationsTable),
+			CreateInit:       createSchema + fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  id SERIAL PRIMARY KEY, \n  file VARCHAR(255) UNIQUE,\n  migrated BOOLEAN DEFAULT false,\n  checksum VARCHAR(64),\n  applied_at TIMESTAMP,\n  rolled_back_at TIMESTAMP,\n  execution_ms BIGINT,\n  version VARCHAR(64),\n  applied_by VARCHAR(255),\n  out_of_order BOOLEAN DEFAULT false,\n  rolled_back_by VARCHAR(255),\n  rollback_ms BIGINT\n);", t),
+			Insert:           fmt.Sprintf("INSERT INTO %s (file, migrated) VALUES ($1, $2)", t),
+			Update:           fmt.Sprintf("UPDATE %s SET migrated = $1 WHERE file = $2", t),
+			MarkApplied:      fmt.Sprintf("UPDATE %s SET migrated = true, checksum = $1, applied_at = CURRENT_TIMESTAMP, rolled_back_at = NULL, execution_ms = $2, version = $3, applied_by = $4, out_of_order = $5 WHERE file = $6", t),
+			MarkRolledBack:   fmt.Sprintf("UPDATE %s SET migrated = false, rolled_back_at = CURRENT_TIMESTAMP, rollback_ms = $1, rolled_back_by = $2 WHERE file = $3", t),
+			UpdateChecksum:   fmt.Sprintf("UPDATE %s SET checksum = $1 WHERE file = $2", t),
+			Delete:           fmt.Sprintf("DELETE FROM %s WHERE file = $1", t),
+			SelectStatus:     fmt.Sprintf("SELECT migrated FROM %s WHERE file = $1", t),
+			SelectOutOfOrder: fmt.Sprintf("SELECT out_of_order FROM %s WHERE file = $1", t),
+			SelectID:         fmt.Sprintf("SELECT id FROM %s WHERE file = $1", t),
+			UpdateID:         fmt.Sprintf("UPDATE %s SET id = $1 WHERE file = $2", t),
+			ListTables:       fmt.Sprintf("SELECT CASE WHEN schemaname = 'public' THEN tablename ELSE schemaname || '.' || tablename END FROM pg_tables WHERE schemaname IN (%s) ORDER BY schemaname, tablename;", c.pgSchemaList()),
+			ListCols:         "SELECT column_name FROM information_schema.columns WHERE table_schema = 'public' AND table_name = $1 ORDER BY ordinal_position;",
+			ListTrackingCols: fmt.Sprintf("SELECT column_name FROM information_schema.columns WHERE table_schema = '%s' AND table_name = '%s' ORDER BY ordinal_position;", schema, c.MigrationsTable),
 		}
 	case "mysql", "mariadb":
 		return Dialect{
-			Type:         dbType,
-			TableExists:  "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = '_schema_migrations'",
-			CreateInit:   "CREATE TABLE IF NOT EXISTS _schema_migrations (\n  id INT PRIMARY KEY AUTO_INCREMENT, \n  file VARCHAR(255) UNIQUE,\n  migrated BOOLEAN DEFAULT false\n);",
-			Insert:       "INSERT INTO _schema_migrations (file, migrated) VALUES (?, ?)",
-			Update:       "UPDATE _schema_migrations SET migrated = ? WHERE file = ?",
-			Delete:       "DELETE FROM _schema_migrations WHERE file = ?",
-			SelectStatus: "SELECT migrated FROM _schema_migrations WHERE file = ?",
-			ListTables:   "SHOW TABLES;",
-			ListCols:     "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position;",
+			Type:             dbType,
+			TableExists:      fmt.Sprintf("SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = '%s'", c.MigrationsTable),
+			CreateInit:       fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  id INT PRIMARY KEY AUTO_INCREMENT, \n  file VARCHAR(255) UNIQUE,\n  migrated BOOLEAN DEFAULT false,\n  checksum VARCHAR(64),\n  applied_at DATETIME,\n  rolled_back_at DATETIME,\n  execution_ms BIGINT,\n  version VARCHAR(64),\n  applied_by VARCHAR(255),\n  out_of_order BOOLEAN DEFAULT false,\n  rolled_back_by VARCHAR(255),\n  rollback_ms BIGINT\n);", t),
+			Insert:           fmt.Sprintf("INSERT INTO %s (file, migrated) VALUES (?, ?)", t),
+			Update:           fmt.Sprintf("UPDATE %s SET migrated = ? WHERE file = ?", t),
+			MarkApplied:      fmt.Sprintf("UPDATE %s SET migrated = true, checksum = ?, applied_at = CURRENT_TIMESTAMP, rolled_back_at = NULL, execution_ms = ?, version = ?, applied_by = ?, out_of_order = ? WHERE file = ?", t),
+			MarkRolledBack:   fmt.Sprintf("UPDATE %s SET migrated = false, rolled_back_at = CURRENT_TIMESTAMP, rollback_ms = ?, rolled_back_by = ? WHERE file = ?", t),
+			UpdateChecksum:   fmt.Sprintf("UPDATE %s SET checksum = ? WHERE file = ?", t),
+			Delete:           fmt.Sprintf("DELETE FROM %s WHERE file = ?", t),
+			SelectStatus:     fmt.Sprintf("SELECT migrated FROM %s WHERE file = ?", t),
+			SelectOutOfOrder: fmt.Sprintf("SELECT out_of_order FROM %s WHERE file = ?", t),
+			SelectID:         fmt.Sprintf("SELECT id FROM %s WHERE file = ?", t),
+			UpdateID:         fmt.Sprintf("UPDATE %s SET id = ? WHERE file = ?", t),
+			ListTables:       "SHOW TABLES;",
+			ListCols:         "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position;",
+			ListTrackingCols: fmt.Sprintf("SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = '%s' ORDER BY ordinal_position;", c.MigrationsTable),
 		}
 	}
 	return Dialect{}
@@ -120,13 +249,19 @@ type schemaDriver interface {
 	Enums(ctx context.Context) ([]Enum, error)
 }
 
+// InspectSchema reads the schema of db, leaving out the tables of the default Config.
 func InspectSchema(ctx context.Context, db *sql.DB, dbType string) (*Database, error) {
+	return Config{}.InspectSchema(ctx, db, dbType)
+}
+
+// InspectSchema reads the schema of db, leaving out the tables c names and other internal tables.
+func (c Config) InspectSchema(ctx context.Context, db *sql.DB, dbType string) (*Database, error) {
 	var drv schemaDriver
 	switch dbType {
 	case "sqlite", "libsql", "turso", "tursosync":
 		drv = &sqliteDriver{db}
 	case "postgres":
-		drv = &postgresDriver{db}
+		drv = &postgresDriver{db, c}
 	case "mysql", "mariadb":
 		drv = &mysqlDriver{db}
 	default:
@@ -145,6 +280,9 @@ func InspectSchema(ctx context.Context, db *sql.DB, dbType string) (*Database, e
 
 	var tables []Table
 	for _, tName := range tableNames {
+		if c.IsInternalTable(tName) {
+			continue
+		}
 		cols, err := drv.Columns(ctx, tName)
 		if err != nil {
 			return nil, err
@@ -181,7 +319,7 @@ type sqliteDriver struct{ db *sql.DB }
 func (s *sqliteDriver) Name(ctx context.Context) (string, error) { return "sqlite", nil }
 
 func (s *sqliteDriver) Tables(ctx context.Context) ([]string, error) {
-	tables, err := queryStrings(ctx, s.db, "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' AND name != '_schema_migrations'")
+	tables, err := queryStrings(ctx, s.db, "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'")
 	if err != nil {
 		return nil, err
 	}
@@ -332,7 +470,10 @@ func (s *sqliteDriver) Indexes(ctx context.Context, table string) ([]Index, erro
 	return idxs, nil
 }
 
-type postgresDriver struct{ db *sql.DB }
+type postgresDriver struct {
+	db  *sql.DB
+	cfg Config
+}
 
 func (p *postgresDriver) Name(ctx context.Context) (string, error) {
 	var name string
@@ -341,11 +482,26 @@ func (p *postgresDriver) Name(ctx context.Context) (string, error) {
 }
 
 func (p *postgresDriver) Tables(ctx context.Context) ([]string, error) {
-	return queryStrings(ctx, p.db, "SELECT table_name FROM information_schema.tables WHERE table_schema = 'public' AND table_type = 'BASE TABLE' ORDER BY table_name")
+	q := fmt.Sprintf("SELECT table_schema, table_name FROM information_schema.tables WHERE table_schema IN (%s) AND table_type = 'BASE TABLE' ORDER BY table_schema, table_name", p.cfg.pgSchemaList())
+	rows, err := p.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var schema, name string
+		if err := rows.Scan(&schema, &name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, qualifiedName(schema, name))
+	}
+	return tables, rows.Err()
 }
 
 func (p *postgresDriver) Enums(ctx context.Context) ([]Enum, error) {
-	rows, err := p.db.QueryContext(ctx, "SELECT t.typname, e.enumlabel FROM pg_type t JOIN pg_enum e ON t.oid = e.enumtypid JOIN pg_namespace n ON n.oid = t.typnamespace WHERE n.nspname = 'public' ORDER BY t.typname, e.enumsortorder")
+	q := fmt.Sprintf("SELECT n.nspname, t.typname, e.enumlabel FROM pg_type t JOIN pg_enum e ON t.oid = e.enumtypid JOIN pg_namespace n ON n.oid = t.typnamespace WHERE n.nspname IN (%s) ORDER BY n.nspname, t.typname, e.enumsortorder", p.cfg.pgSchemaList())
+	rows, err := p.db.QueryContext(ctx, q)
 	if err != nil {
 		return nil, err
 	}
@@ -353,8 +509,9 @@ func (p *postgresDriver) Enums(ctx context.Context) ([]Enum, error) {
 	enumMap := make(map[string][]string)
 	var names []string
 	for rows.Next() {
-		var n, v string
-		rows.Scan(&n, &v)
+		var schema, n, v string
+		rows.Scan(&schema, &n, &v)
+		n = qualifiedName(schema, n)
 		if _, ok := enumMap[n]; !ok {
 			names = append(names, n)
 		}
@@ -368,22 +525,23 @@ func (p *postgresDriver) Enums(ctx context.Context) ([]Enum, error) {
 }
 
 func (p *postgresDriver) Columns(ctx context.Context, table string) ([]Column, error) {
-	q := `SELECT column_name, data_type, udt_name, character_maximum_length, numeric_precision, numeric_scale, is_nullable, column_default 
-	      FROM information_schema.columns WHERE table_schema = 'public' AND table_name = $1 ORDER BY ordinal_position`
-	rows, err := p.db.QueryContext(ctx, q, table)
+	schema, name := splitQualified(table)
+	q := `SELECT column_name, data_type, udt_schema, udt_name, character_maximum_length, numeric_precision, numeric_scale, is_nullable, column_default 
+	      FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2 ORDER BY ordinal_position`
+	rows, err := p.db.QueryContext(ctx, q, schema, name)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var cols []Column
 	for rows.Next() {
-		var name, dtype, udt, isNull string
+		var name, dtype, udtSchema, udt, isNull string
 		var charMax, numPrec, numScale sql.NullInt64
 		var def sql.NullString
-		rows.Scan(&name, &dtype, &udt, &charMax, &numPrec, &numScale, &isNull, &def)
+		rows.Scan(&name, &dtype, &udtSchema, &udt, &charMax, &numPrec, &numScale, &isNull, &def)
 		cols = append(cols, Column{
 			Name: name, IsNullable: isNull == "YES", DefaultValue: def.String,
-			Type: DataType(formatPgType(dtype, udt, charMax, numPrec, numScale)),
+			Type: DataType(formatPgType(dtype, qualifiedName(udtSchema, udt), charMax, numPrec, numScale)),
 		})
 	}
 	return cols, nil
@@ -391,13 +549,14 @@ func (p *postgresDriver) Columns(ctx context.Context, table string) ([]Column, e
 
 func (p *postgresDriver) Constraints(ctx context.Context, table string) ([]Constraint, error) {
 	var cs []Constraint
+	schema, name := splitQualified(table)
 	// PK & Unique
 	q := `SELECT tc.constraint_name, tc.constraint_type, kcu.column_name 
 	      FROM information_schema.table_constraints tc 
-	      JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name 
-	      WHERE tc.table_schema='public' AND tc.table_name=$1 AND tc.constraint_type IN ('PRIMARY KEY', 'UNIQUE') 
+	      JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema 
+	      WHERE tc.table_schema=$1 AND tc.table_name=$2 AND tc.constraint_type IN ('PRIMARY KEY', 'UNIQUE') 
 	      ORDER BY tc.constraint_name, kcu.ordinal_position`
-	rows, err := p.db.QueryContext(ctx, q, table)
+	rows, err := p.db.QueryContext(ctx, q, schema, name)
 	if err == nil {
 		defer rows.Close()
 		cmap := make(map[string]*Constraint)
@@ -420,28 +579,30 @@ func (p *postgresDriver) Constraints(ctx context.Context, table string) ([]Const
 		}
 	}
 	// FKs
-	qFK := `SELECT con.conname, ref_rel.relname, con.conkey, con.confkey, con.confdeltype, con.confupdtype
-			FROM pg_constraint con JOIN pg_class src ON src.oid=con.conrelid JOIN pg_class ref_rel ON ref_rel.oid=con.confrelid
-			WHERE src.relname=$1 AND con.contype='f'`
-	rows, err = p.db.QueryContext(ctx, qFK, table)
+	// Referenced tables in other schemas are named schema.table.
+	qFK := `SELECT con.conname, ref_ns.nspname, ref_rel.relname, con.conkey, con.confkey, con.confdeltype, con.confupdtype
+			FROM pg_constraint con JOIN pg_class src ON src.oid=con.conrelid JOIN pg_namespace src_ns ON src_ns.oid=src.relnamespace
+			JOIN pg_class ref_rel ON ref_rel.oid=con.confrelid JOIN pg_namespace ref_ns ON ref_ns.oid=ref_rel.relnamespace
+			WHERE src_ns.nspname=$1 AND src.relname=$2 AND con.contype='f'`
+	rows, err = p.db.QueryContext(ctx, qFK, schema, name)
 	if err == nil {
 		defer rows.Close()
 		for rows.Next() {
-			var name, refT string
+			var conName, refSchema, refT string
 			var k1, k2 []byte
 			var dt, ut []byte // raw chars
-			rows.Scan(&name, &refT, &k1, &k2, &dt, &ut)
-			cols := resolvePgCols(ctx, p.db, table, parseInt16Array(string(k1)))
-			refCols := resolvePgCols(ctx, p.db, refT, parseInt16Array(string(k2)))
+			rows.Scan(&conName, &refSchema, &refT, &k1, &k2, &dt, &ut)
+			cols := resolvePgCols(ctx, p.db, schema, name, parseInt16Array(string(k1)))
+			refCols := resolvePgCols(ctx, p.db, refSchema, refT, parseInt16Array(string(k2)))
 			cs = append(cs, Constraint{
-				Name: name, Kind: ForeignKey, ReferenceTable: refT, Columns: cols, ReferenceColumns: refCols,
+				Name: conName, Kind: ForeignKey, ReferenceTable: qualifiedName(refSchema, refT), Columns: cols, ReferenceColumns: refCols,
 				OnDelete: parsePgRule(dt), OnUpdate: parsePgRule(ut),
 			})
 		}
 	}
 	// Checks
-	qChk := `SELECT con.conname, pg_get_constraintdef(con.oid) FROM pg_constraint con JOIN pg_class r ON r.oid=con.conrelid WHERE r.relname=$1 AND con.contype='c'`
-	rows, err = p.db.QueryContext(ctx, qChk, table)
+	qChk := `SELECT con.conname, pg_get_constraintdef(con.oid) FROM pg_constraint con JOIN pg_class r ON r.oid=con.conrelid JOIN pg_namespace n ON n.oid=r.relnamespace WHERE n.nspname=$1 AND r.relname=$2 AND con.contype='c'`
+	rows, err = p.db.QueryContext(ctx, qChk, schema, name)
 	if err == nil {
 		defer rows.Close()
 		for rows.Next() {
@@ -460,9 +621,11 @@ func (p *postgresDriver) Indexes(ctx context.Context, table string) ([]Index, er
 	q := `SELECT i.relname, array_to_string(array_agg(a.attname ORDER BY array_position(ix.indkey, a.attnum)), ', '), ix.indisunique
 		  FROM pg_class t JOIN pg_index ix ON t.oid = ix.indrelid JOIN pg_class i ON i.oid = ix.indexrelid
 		  JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(ix.indkey)
+		  JOIN pg_namespace n ON n.oid = t.relnamespace
 		  LEFT JOIN pg_constraint c ON c.conindid = i.oid
-		  WHERE t.relname=$1 AND c.conindid IS NULL GROUP BY i.relname, ix.indisunique, ix.indkey`
-	rows, err := p.db.QueryContext(ctx, q, table)
+		  WHERE n.nspname=$1 AND t.relname=$2 AND c.conindid IS NULL GROUP BY i.relname, ix.indisunique, ix.indkey`
+	schema, name := splitQualified(table)
+	rows, err := p.db.QueryContext(ctx, q, schema, name)
 	if err != nil {
 		return nil, err
 	}
@@ -485,7 +648,7 @@ func (m *mysqlDriver) Name(ctx context.Context) (string, error) {
 	return n, err
 }
 func (m *mysqlDriver) Tables(ctx context.Context) ([]string, error) {
-	return queryStrings(ctx, m.db, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' AND table_name != '_schema_migrations'")
+	return queryStrings(ctx, m.db, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'")
 }
 func (m *mysqlDriver) Enums(ctx context.Context) ([]Enum, error) {
 	q := `SELECT table_name, column_name, column_type FROM information_schema.columns WHERE table_schema = DATABASE() AND data_type = 'enum'`
@@ -707,18 +870,18 @@ func formatPgType(dt, udt string, max, prec, scale sql.NullInt64) string {
 	}
 }
 
-func resolvePgCols(ctx context.Context, db *sql.DB, table string, nums []int16) []string {
+func resolvePgCols(ctx context.Context, db *sql.DB, schema, table string, nums []int16) []string {
 	if len(nums) == 0 {
 		return nil
 	}
-	args := make([]any, len(nums)+1)
-	args[0] = table
+	args := make([]any, len(nums)+2)
+	args[0], args[1] = schema, table
 	placeholders := make([]string, len(nums))
 	for i, n := range nums {
-		args[i+1] = n
-		placeholders[i] = fmt.Sprintf("$%d", i+2)
+		args[i+2] = n
+		placeholders[i] = fmt.Sprintf("$%d", i+3)
 	}
-	q := fmt.Sprintf("SELECT attname, attnum FROM pg_attribute JOIN pg_class ON pg_class.oid=pg_attribute.attrelid WHERE relname=$1 AND attnum IN (%s)", strings.Join(placeholders, ","))
+	q := fmt.Sprintf("SELECT attname, attnum FROM pg_attribute JOIN pg_class ON pg_class.oid=pg_attribute.attrelid JOIN pg_namespace ON pg_namespace.oid=pg_class.relnamespace WHERE nspname=$1 AND relname=$2 AND attnum IN (%s)", strings.Join(placeholders, ","))
 	rows, err := db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return nil
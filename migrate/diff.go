@@ -1,7 +1,8 @@
-package main
+package migrate
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -59,6 +60,12 @@ func (td *TableDiff) HasChanges() bool {
 		len(td.ConstraintsToAdd) > 0 || len(td.ConstraintsToDrop) > 0
 }
 
+// IsEmpty returns true if the two schemas are the same.
+func (d *SchemaDiff) IsEmpty() bool {
+	return len(d.TablesToCreate) == 0 && len(d.TablesToDrop) == 0 && len(d.TablesToAlter) == 0 &&
+		len(d.TablesToRename) == 0 && len(d.EnumsToAlter) == 0
+}
+
 // DiffSchemas compares the current database state with the desired local schema.
 func DiffSchemas(current, desired *Database) SchemaDiff {
 	diff := SchemaDiff{}
@@ -71,7 +78,7 @@ func DiffSchemas(current, desired *Database) SchemaDiff {
 
 	desiredTables := make(map[string]Table)
 	for _, t := range desired.Tables {
-		if isInternalTable(t.Name) {
+		if IsInternalTable(t.Name) {
 			continue
 		}
 		desiredTables[t.Name] = t
@@ -115,13 +122,7 @@ func DiffSchemas(current, desired *Database) SchemaDiff {
 	for name, cTable := range currentTables {
 		if _, exists := desiredTables[name]; !exists {
 			// NEW: Ignore internal migration, SQLite, and Turso sync tables
-			isInternal := name == "_schema_migrations" ||
-				strings.HasPrefix(name, "sqlite_") ||
-				strings.HasPrefix(name, "turso_cdc") ||
-				strings.HasPrefix(name, "turso_sync") ||
-				strings.HasPrefix(name, "libsql_")
-
-			if !isInternal {
+			if !IsInternalTable(name) {
 				diff.TablesToDrop = append(diff.TablesToDrop, cTable)
 			}
 		}
@@ -437,6 +438,10 @@ func DiffTables(current, desired Table) TableDiff {
 func GenerateMigrationSQL(diff SchemaDiff, dbType string) string {
 	var statements []string
 
+	if dbType == "postgres" {
+		statements = append(statements, createSchemasSQL(diff)...)
+	}
+
 	for _, eDiff := range diff.EnumsToAlter {
 		switch dbType {
 		case "postgres":
@@ -458,6 +463,10 @@ func GenerateMigrationSQL(diff SchemaDiff, dbType string) string {
 	}
 
 	for _, rename := range diff.TablesToRename {
+		if dbType == "postgres" {
+			statements = append(statements, pgRenameTableSQL(rename)...)
+			continue
+		}
 		statements = append(statements, fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", rename.OldName, rename.NewName))
 	}
 
@@ -548,6 +557,10 @@ func GenerateMigrationSQL(diff SchemaDiff, dbType string) string {
 		for _, idx := range tDiff.IndexesToDrop {
 			if dbType == "mysql" || dbType == "mariadb" {
 				statements = append(statements, fmt.Sprintf("DROP INDEX %s ON %s;", idx.Name, tDiff.TableName))
+			} else if dbType == "postgres" {
+				// Indexes live in the schema of their table.
+				schema, _ := splitQualified(tDiff.TableName)
+				statements = append(statements, fmt.Sprintf("DROP INDEX %s;", qualifiedName(schema, idx.Name)))
 			} else {
 				statements = append(statements, fmt.Sprintf("DROP INDEX %s;", idx.Name))
 			}
@@ -575,6 +588,54 @@ func GenerateMigrationSQL(diff SchemaDiff, dbType string) string {
 	return strings.Join(statements, "\n\n")
 }
 
+// createSchemasSQL creates the Postgres schemas outside public that new or renamed tables are put in.
+func createSchemasSQL(diff SchemaDiff) []string {
+	var names []string
+	for _, t := range diff.TablesToCreate {
+		names = append(names, t.Name)
+	}
+	for _, rename := range diff.TablesToRename {
+		names = append(names, rename.NewName)
+	}
+	return createSchemaStatements(names)
+}
+
+// createSchemaStatements returns a CREATE SCHEMA statement for every schema outside public that
+// names are qualified with, in alphabetical order.
+func createSchemaStatements(names []string) []string {
+	seen := make(map[string]bool)
+	var schemas []string
+	for _, name := range names {
+		if schema, _ := splitQualified(name); schema != "public" && !seen[schema] {
+			seen[schema] = true
+			schemas = append(schemas, schema)
+		}
+	}
+	sort.Strings(schemas)
+	stmts := make([]string, len(schemas))
+	for i, schema := range schemas {
+		stmts[i] = fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;", schema)
+	}
+	return stmts
+}
+
+// pgRenameTableSQL renames a Postgres table, moving it to another schema first if the new name
+// has a different one, since RENAME TO only takes an unqualified name.
+func pgRenameTableSQL(rename TableRename) []string {
+	oldSchema, oldTable := splitQualified(rename.OldName)
+	newSchema, newTable := splitQualified(rename.NewName)
+	var stmts []string
+	current := rename.OldName
+	if oldSchema != newSchema {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s SET SCHEMA %s;", current, newSchema))
+		current = qualifiedName(newSchema, oldTable)
+	}
+	if oldTable != newTable {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", current, newTable))
+	}
+	return stmts
+}
+
 func generateCreateTableSQL(t Table, dbType string) string {
 	var lines []string
 	inlinePKs := make(map[string]bool)
@@ -729,9 +790,18 @@ func generateSQLiteTableRebuild(tDiff TableDiff) string {
 	return strings.Join(stmts, "\n")
 }
 
-// isInternalTable checks if a table is a system/replication table that should be ignored
-func isInternalTable(name string) bool {
-	return name == "_schema_migrations" ||
+// IsInternalTable checks if a table is a system/replication table that should be ignored
+func IsInternalTable(name string) bool {
+	return Config{}.IsInternalTable(name)
+}
+
+// IsInternalTable checks if a table is one of the tracking tables of c or a system/replication
+// table that should be ignored.
+func (c Config) IsInternalTable(name string) bool {
+	c = c.withDefaults()
+	return name == c.MigrationsTable ||
+		name == c.TrackingTable() ||
+		name == c.LockTable ||
 		strings.HasPrefix(name, "sqlite_") ||
 		strings.HasPrefix(name, "turso_cdc") ||
 		strings.HasPrefix(name, "turso_sync") ||
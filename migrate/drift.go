@@ -0,0 +1,172 @@
+package migrate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Directions of a Drift.
+const (
+	OnlyInDatabase = "only_in_database"
+	OnlyInSchema   = "only_in_schema"
+	Changed        = "changed"
+)
+
+// Drift is one difference between a live database and db.schema, as reported by `schema check`.
+type Drift struct {
+	// Object is "table", "column", "index", "constraint" or "enum".
+	Object string
+	// Name is the object, prefixed with its table for columns, indexes and constraints.
+	Name string
+	// Direction says where the object exists: OnlyInDatabase, OnlyInSchema or Changed.
+	Direction string
+	// Detail describes a changed object, e.g. "database: TEXT, db.schema: TEXT NOT NULL".
+	Detail string
+}
+
+// DescribeDrift lists the differences of diff, the result of DiffSchemas with the live database
+// as current and db.schema as desired, sorted by name.
+func DescribeDrift(diff SchemaDiff) []Drift {
+	var drift []Drift
+	for _, t := range diff.TablesToDrop {
+		drift = append(drift, Drift{Object: "table", Name: t.Name, Direction: OnlyInDatabase})
+	}
+	for _, t := range diff.TablesToCreate {
+		drift = append(drift, Drift{Object: "table", Name: t.Name, Direction: OnlyInSchema})
+	}
+	for _, r := range diff.TablesToRename {
+		drift = append(drift, Drift{Object: "table", Name: r.NewName, Direction: Changed, Detail: fmt.Sprintf("named %s in the database", r.OldName)})
+	}
+	for _, e := range diff.EnumsToAlter {
+		drift = append(drift, Drift{Object: "enum", Name: e.Name, Direction: Changed, Detail: "db.schema adds " + strings.Join(e.ValuesToAdd, ", ")})
+	}
+
+	for _, td := range diff.TablesToAlter {
+		qualify := func(name string) string { return td.TableName + "." + name }
+		for _, c := range td.ColumnsToDrop {
+			drift = append(drift, Drift{Object: "column", Name: qualify(c.Name), Direction: OnlyInDatabase, Detail: describeColumn(c)})
+		}
+		for _, c := range td.ColumnsToAdd {
+			drift = append(drift, Drift{Object: "column", Name: qualify(c.Name), Direction: OnlyInSchema, Detail: describeColumn(c)})
+		}
+		for _, r := range td.ColumnsToRename {
+			drift = append(drift, Drift{Object: "column", Name: qualify(r.NewName), Direction: Changed, Detail: fmt.Sprintf("named %s in the database", r.OldName)})
+		}
+		for _, c := range td.ColumnsToModify {
+			drift = append(drift, Drift{Object: "column", Name: qualify(c.New.Name), Direction: Changed, Detail: fmt.Sprintf("database: %s, db.schema: %s", describeColumn(c.Old), describeColumn(c.New))})
+		}
+		for _, idx := range td.IndexesToDrop {
+			drift = append(drift, Drift{Object: "index", Name: qualify(idx.Name), Direction: OnlyInDatabase, Detail: describeIndex(idx)})
+		}
+		for _, idx := range td.IndexesToAdd {
+			drift = append(drift, Drift{Object: "index", Name: qualify(idx.Name), Direction: OnlyInSchema, Detail: describeIndex(idx)})
+		}
+		dropped, added := withoutEquivalentConstraints(td.ConstraintsToDrop, td.ConstraintsToAdd)
+		for _, c := range dropped {
+			drift = append(drift, Drift{Object: "constraint", Name: qualify(constraintName(c)), Direction: OnlyInDatabase, Detail: describeConstraint(c)})
+		}
+		for _, c := range added {
+			drift = append(drift, Drift{Object: "constraint", Name: qualify(constraintName(c)), Direction: OnlyInSchema, Detail: describeConstraint(c)})
+		}
+	}
+
+	sort.SliceStable(drift, func(i, j int) bool {
+		if drift[i].Name != drift[j].Name {
+			return drift[i].Name < drift[j].Name
+		}
+		return drift[i].Direction < drift[j].Direction
+	})
+	return drift
+}
+
+// withoutEquivalentConstraints removes the constraints found in both dropped and added that only
+// differ in how they spell the default foreign key action. SQLite and Postgres report NO ACTION
+// where db.schema leaves the action out, which would otherwise show up as drift right after a pull.
+func withoutEquivalentConstraints(dropped, added []Constraint) ([]Constraint, []Constraint) {
+	key := func(c Constraint) string {
+		c.OnDelete, c.OnUpdate = normalizeFKAction(c.OnDelete), normalizeFKAction(c.OnUpdate)
+		return constraintName(c) + " " + describeConstraint(c)
+	}
+	unmatched := make(map[string]int)
+	for _, c := range added {
+		unmatched[key(c)]++
+	}
+	var keptDropped []Constraint
+	matched := make(map[string]int)
+	for _, c := range dropped {
+		if k := key(c); unmatched[k] > 0 {
+			unmatched[k]--
+			matched[k]++
+			continue
+		}
+		keptDropped = append(keptDropped, c)
+	}
+	var keptAdded []Constraint
+	for _, c := range added {
+		if k := key(c); matched[k] > 0 {
+			matched[k]--
+			continue
+		}
+		keptAdded = append(keptAdded, c)
+	}
+	return keptDropped, keptAdded
+}
+
+// normalizeFKAction returns a foreign key action in upper case, with NO ACTION, the default, as "".
+func normalizeFKAction(action string) string {
+	action = strings.ToUpper(strings.Join(strings.Fields(action), " "))
+	if action == "NO ACTION" {
+		return ""
+	}
+	return action
+}
+
+func describeColumn(c Column) string {
+	desc := string(c.Type)
+	if !c.IsNullable {
+		desc += " NOT NULL"
+	}
+	if c.DefaultValue != "" {
+		desc += " DEFAULT " + c.DefaultValue
+	}
+	return desc
+}
+
+func describeIndex(idx Index) string {
+	if idx.IsUnique {
+		return fmt.Sprintf("UNIQUE (%s)", strings.Join(idx.Columns, ", "))
+	}
+	return fmt.Sprintf("(%s)", strings.Join(idx.Columns, ", "))
+}
+
+// constraintName returns the name of c, or its kind and columns when db.schema leaves it unnamed.
+func constraintName(c Constraint) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if c.Kind == Check {
+		return strings.ToLower(string(c.Kind))
+	}
+	return fmt.Sprintf("%s(%s)", strings.ToLower(string(c.Kind)), strings.Join(c.Columns, ", "))
+}
+
+func describeConstraint(c Constraint) string {
+	switch c.Kind {
+	case ForeignKey:
+		desc := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s(%s)", strings.Join(c.Columns, ", "), c.ReferenceTable, strings.Join(c.ReferenceColumns, ", "))
+		if c.OnDelete != "" {
+			desc += " ON DELETE " + c.OnDelete
+		}
+		if c.OnUpdate != "" {
+			desc += " ON UPDATE " + c.OnUpdate
+		}
+		return desc
+	case Check:
+		return fmt.Sprintf("CHECK (%s)", c.CheckExpression)
+	case PrimaryKey:
+		return fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(c.Columns, ", "))
+	default:
+		return fmt.Sprintf("UNIQUE (%s)", strings.Join(c.Columns, ", "))
+	}
+}
@@ -0,0 +1,77 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// pulledSchema is db.schema as `schema pull` writes it for the tables of driftMigrations.
+const pulledSchema = `db = "sqlite"
+url = env("SCHEMA_DB_URL")
+
+table a (
+  id INTEGER PRIMARY KEY
+)
+
+table b (
+  id INTEGER PRIMARY KEY,
+  a_id INTEGER REFERENCES a(id)
+)
+
+table c (
+  id INTEGER PRIMARY KEY,
+  a_id INTEGER REFERENCES a(id) ON DELETE CASCADE
+)
+`
+
+var driftMigrations = fstest.MapFS{
+	"1_tables.sql": {Data: []byte("CREATE TABLE a (id INTEGER PRIMARY KEY);\nCREATE TABLE b (id INTEGER PRIMARY KEY, a_id INTEGER REFERENCES a(id));\nCREATE TABLE c (id INTEGER PRIMARY KEY, a_id INTEGER REFERENCES a(id) ON DELETE CASCADE);\n")},
+}
+
+// driftAfterMigrate migrates a fresh database and returns its drift from schema.
+func driftAfterMigrate(t *testing.T, schema string) []Drift {
+	t.Helper()
+	ctx := context.Background()
+	m := newTestMigrator(t, driftMigrations)
+	if _, err := m.Migrate(ctx, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	current, err := InspectSchema(ctx, m.DB, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaPath := filepath.Join(t.TempDir(), "db.schema")
+	if err := os.WriteFile(schemaPath, []byte(schema), 0644); err != nil {
+		t.Fatal(err)
+	}
+	desired, err := ParseSchemaFile(schemaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return DescribeDrift(DiffSchemas(current, desired))
+}
+
+func TestNoDriftAfterMigrate(t *testing.T) {
+	if drift := driftAfterMigrate(t, pulledSchema); len(drift) > 0 {
+		t.Errorf("drift right after migrate = %+v, want none", drift)
+	}
+}
+
+func TestDriftInForeignKeyAction(t *testing.T) {
+	drift := driftAfterMigrate(t, strings.Replace(pulledSchema, "ON DELETE CASCADE", "ON DELETE SET NULL", 1))
+	if len(drift) != 2 || drift[0].Name != "c.foreign_key(a_id)" || drift[1].Name != "c.foreign_key(a_id)" {
+		t.Errorf("drift = %+v, want the foreign key of c on both sides", drift)
+	}
+}
+
+func TestNormalizeFKAction(t *testing.T) {
+	for action, want := range map[string]string{"": "", "NO ACTION": "", "no  action": "", "cascade": "CASCADE", "SET NULL": "SET NULL"} {
+		if got := normalizeFKAction(action); got != want {
+			t.Errorf("normalizeFKAction(%q) = %q, want %q", action, got, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnsupportedDB is returned for a database type other than sqlite, libsql, turso,
+	// tursosync, postgres, mysql or mariadb.
+	ErrUnsupportedDB = errors.New("unsupported database type")
+	// ErrNoRollback is returned when a migration file has no "-- schema rollback" section.
+	ErrNoRollback = errors.New("no rollback script found")
+	// ErrNotPending is returned when a migrate target isn't a pending migration.
+	ErrNotPending = errors.New("not a pending migration")
+	// ErrNotApplied is returned when a rollback target isn't an applied migration.
+	ErrNotApplied = errors.New("not an applied migration")
+	// ErrNoBaselineTarget is returned by Baseline without a target migration.
+	ErrNoBaselineTarget = errors.New("baseline needs the last migration already in place")
+)
+
+// ChecksumMismatch is an applied migration whose file no longer matches its recorded checksum.
+type ChecksumMismatch struct {
+	File     string
+	Recorded string
+	Current  string
+}
+
+// ChecksumError is returned when applied migration files were edited after they ran.
+type ChecksumError struct {
+	Mismatches []ChecksumMismatch
+}
+
+func (e *ChecksumError) Error() string {
+	lines := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		lines[i] = fmt.Sprintf("  %s (recorded %.12s, now %.12s)", m.File, m.Recorded, m.Current)
+	}
+	return "applied migrations were modified after they ran:\n" + strings.Join(lines, "\n")
+}
+
+// OutOfOrderError is returned when pending migrations are older than the latest applied one.
+type OutOfOrderError struct {
+	Latest string
+	Files  []string
+}
+
+func (e *OutOfOrderError) Error() string {
+	return fmt.Sprintf("pending migrations are older than the latest applied migration %s:\n  %s", e.Latest, strings.Join(e.Files, "\n  "))
+}
+
+// StatementError is returned when a statement of a migration file fails. Line is the line of
+// File the statement starts on.
+type StatementError struct {
+	File      string
+	Line      int
+	Statement string
+	// NoTransaction is set when the file ran without a transaction, so the statements before
+	// the failing one stay applied.
+	NoTransaction bool
+	Err           error
+}
+
+func (e *StatementError) Error() string {
+	msg := fmt.Sprintf("%s:%d: %v\n%s", e.File, e.Line, e.Err, e.Statement)
+	if e.NoTransaction {
+		msg += "\nThe file runs without a transaction, so statements before this one were not rolled back"
+	}
+	return msg
+}
+
+func (e *StatementError) Unwrap() error { return e.Err }
+
+// RollbackMismatchError is returned by VerifyRollback when rolling back File doesn't restore the
+// schema it started from, or applying it again afterwards gives a different schema than the
+// first time. Diff is the SQL that would turn the schema found into the expected one.
+type RollbackMismatchError struct {
+	File      string
+	Reapplied bool
+	Diff      string
+}
+
+func (e *RollbackMismatchError) Error() string {
+	if e.Reapplied {
+		return fmt.Sprintf("applying %s again after its rollback gave a different schema, it differs by:\n%s", e.File, e.Diff)
+	}
+	return fmt.Sprintf("rolling back %s did not restore the schema before it, it differs by:\n%s", e.File, e.Diff)
+}
+
+// PlaceholderError is returned when a migration file uses placeholders that have no value.
+type PlaceholderError struct {
+	File         string
+	Placeholders []string
+}
+
+func (e *PlaceholderError) Error() string {
+	return fmt.Sprintf("unresolved placeholders in %s: %s", e.File, strings.Join(e.Placeholders, ", "))
+}
+
+// LockTimeoutError is returned when another runner held the migration lock for longer than
+// the timeout. Table is set when the lock is a row that a crashed runner may have left behind.
+type LockTimeoutError struct {
+	Timeout time.Duration
+	Holder  string
+	Table   string
+}
+
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s waiting for the migration lock held by %s", e.Timeout, e.Holder)
+}
+
+// TrackingTableMovedError is returned when the configured migrations table doesn't exist but the
+// default one does, so the migrations were tracked under the old name.
+type TrackingTableMovedError struct {
+	Table    string
+	Existing string
+}
+
+func (e *TrackingTableMovedError) Error() string {
+	return fmt.Sprintf("migrations table %s doesn't exist, but %s does", e.Table, e.Existing)
+}
+
+// UnsquashableStatement is a statement of a squashed file that the snapshot would leave out.
+type UnsquashableStatement struct {
+	File      string
+	Line      int
+	Statement string
+}
+
+// UnsquashableError is returned by Squash when the files have statements other than table,
+// index, enum and schema DDL, which the snapshot can't carry over.
+type UnsquashableError struct {
+	Statements []UnsquashableStatement
+}
+
+func (e *UnsquashableError) Error() string {
+	var b strings.Builder
+	b.WriteString("the squashed files have statements the snapshot would drop:")
+	for _, s := range e.Statements {
+		first, _, _ := strings.Cut(s.Statement, "\n")
+		fmt.Fprintf(&b, "\n  %s:%d: %s", s.File, s.Line, first)
+	}
+	return b.String()
+}
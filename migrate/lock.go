@@ -0,0 +1,160 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// migrationLockKey identifies the Postgres advisory lock taken by migrate, rollback and generate.
+const migrationLockKey int64 = 0x736368656d61
+
+// lockPollInterval is how often a waiting runner retries a held lock.
+const lockPollInterval = 500 * time.Millisecond
+
+// Lock takes a cross-process lock so that only one runner migrates a database at a time, waiting
+// up to timeout (one minute if zero) for another holder to finish. The returned func releases the
+// lock and is safe to call more than once. A *LockTimeoutError names the holder on timeout.
+func (m *Migrator) Lock(ctx context.Context, timeout time.Duration) (func(), error) {
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	holder := fmt.Sprintf("%s (pid %d)", m.Actor, os.Getpid())
+
+	var release func()
+	var err error
+	switch m.DBType {
+	case "postgres":
+		release, err = acquirePostgresLock(ctx, m.DB, holder, timeout)
+	case "mysql", "mariadb":
+		release, err = acquireMySQLLock(ctx, m.DB, timeout)
+	case "sqlite", "libsql", "turso", "tursosync":
+		release, err = acquireSQLiteLock(ctx, m.DB, m.config.LockTable, holder, timeout)
+	default:
+		return nil, fmt.Errorf("%w for migration lock: %s", ErrUnsupportedDB, m.DBType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	released := false
+	return func() {
+		if !released {
+			released = true
+			release()
+		}
+	}, nil
+}
+
+// waitForLock sleeps until the next retry, returning false once the deadline or ctx is done.
+func waitForLock(ctx context.Context, deadline time.Time) bool {
+	if time.Now().After(deadline) {
+		return false
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(lockPollInterval):
+		return true
+	}
+}
+
+func acquirePostgresLock(ctx context.Context, conn *sql.DB, me string, timeout time.Duration) (func(), error) {
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock connection: %w", err)
+	}
+	_, _ = c.ExecContext(ctx, "SELECT set_config('application_name', $1, false)", "schema "+me)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		if err := c.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", migrationLockKey).Scan(&locked); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("taking advisory lock: %w", err)
+		}
+		if locked {
+			return func() {
+				_, _ = c.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+				c.Close()
+			}, nil
+		}
+		if !waitForLock(ctx, deadline) {
+			break
+		}
+	}
+
+	holder := "another session"
+	var pid int
+	var app, usename, addr string
+	q := `SELECT a.pid, COALESCE(a.application_name, ''), COALESCE(a.usename, ''), COALESCE(host(a.client_addr), 'local')
+	      FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+	      WHERE l.locktype = 'advisory' AND l.granted AND ((l.classid::bigint << 32) | l.objid::bigint) = $1 LIMIT 1`
+	if err := c.QueryRowContext(context.Background(), q, migrationLockKey).Scan(&pid, &app, &usename, &addr); err == nil {
+		holder = fmt.Sprintf("'%s' (backend pid %d, user %s from %s)", app, pid, usename, addr)
+	}
+	c.Close()
+	return nil, &LockTimeoutError{Timeout: timeout, Holder: holder}
+}
+
+func acquireMySQLLock(ctx context.Context, conn *sql.DB, timeout time.Duration) (func(), error) {
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock connection: %w", err)
+	}
+
+	var locked sql.NullInt64
+	err = c.QueryRowContext(ctx, "SELECT GET_LOCK(CONCAT('schema_migrations.', DATABASE()), ?)", int(timeout.Seconds())).Scan(&locked)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("taking named lock: %w", err)
+	}
+	if locked.Valid && locked.Int64 == 1 {
+		return func() {
+			_, _ = c.ExecContext(context.Background(), "SELECT RELEASE_LOCK(CONCAT('schema_migrations.', DATABASE()))")
+			c.Close()
+		}, nil
+	}
+
+	holder := "another session"
+	var id int64
+	var mysqlUser, host string
+	q := `SELECT ID, USER, HOST FROM information_schema.PROCESSLIST WHERE ID = IS_USED_LOCK(CONCAT('schema_migrations.', DATABASE()))`
+	if err := c.QueryRowContext(context.Background(), q).Scan(&id, &mysqlUser, &host); err == nil {
+		holder = fmt.Sprintf("connection %d (%s from %s)", id, mysqlUser, host)
+	}
+	c.Close()
+	return nil, &LockTimeoutError{Timeout: timeout, Holder: holder}
+}
+
+// acquireSQLiteLock stores the lock as a single row in table, since SQLite and libSQL have no
+// session level locks that outlive a transaction.
+func acquireSQLiteLock(ctx context.Context, conn *sql.DB, table, me string, timeout time.Duration) (func(), error) {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  id INTEGER PRIMARY KEY,\n  holder VARCHAR(255),\n  acquired_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP\n)", table))
+	if err != nil {
+		return nil, fmt.Errorf("creating %s table: %w", table, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	var holder, acquiredAt string
+	for {
+		_, err := conn.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (id, holder) VALUES (1, ?)", table), me)
+		if err == nil {
+			return func() {
+				_, _ = conn.ExecContext(context.Background(), fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND holder = ?", table), me)
+			}, nil
+		}
+
+		selErr := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT holder, acquired_at FROM %s WHERE id = 1", table)).Scan(&holder, &acquiredAt)
+		if selErr != nil && selErr != sql.ErrNoRows {
+			return nil, fmt.Errorf("reading lock row: %w", selErr)
+		}
+		if !waitForLock(ctx, deadline) {
+			break
+		}
+	}
+
+	return nil, &LockTimeoutError{Timeout: timeout, Holder: fmt.Sprintf("%s since %s", holder, acquiredAt), Table: table}
+}
@@ -0,0 +1,1108 @@
+// Package migrate is the migration engine behind the schema CLI. It applies and rolls back the
+// .sql files of a migrations directory, tracks them in _schema_migrations, and inspects, parses
+// and diffs database schemas.
+//
+// Migrations can be embedded in a Go service and run at startup:
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	sub, _ := fs.Sub(migrations, "migrations")
+//	m, err := migrate.New(db, "postgres", sub)
+//	if err != nil {
+//		return err
+//	}
+//	applied, err := m.Migrate(ctx, migrate.MigrateOptions{})
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migrator applies and rolls back the migrations in an fs.FS against a database.
+type Migrator struct {
+	DB     *sql.DB
+	DBType string
+	// Migrations holds the .sql files at its root, e.g. os.DirFS("schema/migrations").
+	Migrations fs.FS
+	// Repeatable holds migrations that are re-run after the versioned ones whenever their contents
+	// change, such as views and functions. It may be nil.
+	Repeatable fs.FS
+	// Version and Actor are recorded with every migrate in _schema_migrations, and Actor with every
+	// rollback too.
+	Version string
+	Actor   string
+	// Vars are substituted for ${name} placeholders in migration files before they run.
+	// ${env:NAME} placeholders are read from the environment instead.
+	Vars map[string]string
+	// Logf receives progress messages, such as newly tracked files. It may be nil.
+	Logf func(format string, v ...any)
+
+	config  Config
+	dialect Dialect
+	// table is the qualified migrations table of config.
+	table string
+}
+
+// MigrateOptions configures Migrator.Migrate.
+type MigrateOptions struct {
+	// To stops after this migration instead of applying everything pending. Repeatable
+	// migrations are skipped when it is set.
+	To string
+	// File applies only this migration. Naming a file is an explicit request to run it, so one
+	// older than the latest applied migration is recorded as out of order instead of refused.
+	File string
+	// AllowOutOfOrder applies pending migrations older than the latest applied one.
+	AllowOutOfOrder bool
+	// Repair records the current checksums of edited applied migrations instead of failing.
+	Repair bool
+	// LockTimeout is how long to wait for another runner. Zero means one minute.
+	LockTimeout time.Duration
+	// OnApplied is called after each migration is applied, including 0_init.sql when the tracking
+	// table is created. An error stops Migrate. It may be nil.
+	OnApplied func(file string) error
+}
+
+// RollbackOptions configures Migrator.Rollback.
+type RollbackOptions struct {
+	// Steps is how many migrations to roll back. Zero means one.
+	Steps int
+	// To rolls back every migration applied after this one instead.
+	To string
+	// File rolls back only this migration.
+	File   string
+	Repair bool
+	// Untracked runs the rollback sections without marking the migrations as rolled back or
+	// verifying checksums, for files kept outside the tracked migrations directory.
+	Untracked bool
+	// LockTimeout is how long to wait for another runner. Zero means one minute.
+	LockTimeout time.Duration
+	// OnRolledBack is called after each migration is rolled back. An error stops Rollback. It may
+	// be nil.
+	OnRolledBack func(file string) error
+}
+
+// BaselineOptions configures Migrator.Baseline.
+type BaselineOptions struct {
+	// To is the last migration that is already in place in the database. It is required.
+	To string
+	// LockTimeout is how long to wait for another runner. Zero means one minute.
+	LockTimeout time.Duration
+}
+
+// New returns a Migrator for db, whose type is one of sqlite, libsql, turso, tursosync, postgres,
+// mysql or mariadb.
+func New(db *sql.DB, dbType string, migrations fs.FS) (*Migrator, error) {
+	dialect := GetDialect(dbType)
+	if dialect.Type == "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDB, dbType)
+	}
+	return &Migrator{
+		DB:         db,
+		DBType:     dbType,
+		Migrations: migrations,
+		Version:    "dev",
+		Actor:      defaultActor(),
+		config:     Config{}.withDefaults(),
+		dialect:    dialect,
+		table:      DefaultMigrationsTable,
+	}, nil
+}
+
+// Configure sets the tables m keeps its state in and the Postgres schemas it manages, which are
+// the defaults after New.
+func (m *Migrator) Configure(cfg Config) error {
+	if err := cfg.Validate(m.DBType); err != nil {
+		return err
+	}
+	m.config = cfg.withDefaults()
+	m.dialect = cfg.Dialect(m.DBType)
+	m.table = cfg.TrackingTable()
+	return nil
+}
+
+// DriverName returns the database/sql driver name for a database type.
+func DriverName(dbType string) (string, error) {
+	switch dbType {
+	case "sqlite":
+		return "sqlite", nil
+	case "postgres":
+		return "pgx", nil
+	case "mysql", "mariadb":
+		return "mysql", nil
+	case "libsql", "tursosync":
+		return "libsql", nil
+	case "turso":
+		return "turso", nil
+	}
+	return "", fmt.Errorf("%w '%s'", ErrUnsupportedDB, dbType)
+}
+
+// Open opens a database by its type. The caller imports the matching driver, as with sql.Open.
+func Open(dbType, url string) (*sql.DB, error) {
+	driverName, err := DriverName(dbType)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open(driverName, url)
+}
+
+// Migrate applies the pending migrations in version order, then the changed repeatable
+// migrations, while holding the migration lock, and returns the files it applied. It stops at the
+// first failure.
+func (m *Migrator) Migrate(ctx context.Context, opts MigrateOptions) ([]string, error) {
+	unlock, err := m.Lock(ctx, opts.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var applied []string
+	done := func(file string) error {
+		applied = append(applied, file)
+		if opts.OnApplied != nil {
+			return opts.OnApplied(file)
+		}
+		return nil
+	}
+
+	created, err := m.EnsureTrackingTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.Stat(m.Migrations, "0_init.sql"); created && err == nil {
+		if err := done("0_init.sql"); err != nil {
+			return applied, err
+		}
+	}
+	if _, err := m.ReconcileSquashes(ctx); err != nil {
+		return applied, err
+	}
+	if err := m.VerifyChecksums(ctx, opts.Repair); err != nil {
+		return applied, err
+	}
+
+	// New files are tracked as they are applied, so a refused migrate leaves no rows behind.
+	files, err := m.Pending(ctx, false)
+	if err != nil {
+		return applied, err
+	}
+	var outOfOrder map[string]bool
+	if opts.File != "" {
+		files = []string{strings.TrimSuffix(opts.File, ".sql") + ".sql"}
+		outOfOrder, err = m.OutOfOrder(ctx, files, true)
+	} else if files, err = UpTo(files, opts.To); err == nil {
+		outOfOrder, err = m.OutOfOrder(ctx, files, opts.AllowOutOfOrder)
+	}
+	if err != nil {
+		return applied, err
+	}
+
+	for _, file := range files {
+		if outOfOrder[file] {
+			m.logf("Warning: Applying %s out of order.", file)
+		}
+		if err := m.Apply(ctx, file, outOfOrder[file]); err != nil {
+			return applied, fmt.Errorf("migration %s: %w", file, err)
+		}
+		if err := done(file); err != nil {
+			return applied, err
+		}
+	}
+	if opts.To != "" || opts.File != "" {
+		return applied, nil
+	}
+
+	repeatable, err := m.PendingRepeatable(ctx)
+	if err != nil {
+		return applied, err
+	}
+	for _, file := range repeatable {
+		if err := m.Apply(ctx, file, false); err != nil {
+			return applied, fmt.Errorf("migration %s: %w", file, err)
+		}
+		if err := done(file); err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
+
+// Rollback rolls back applied migrations newest first while holding the migration lock, and
+// returns the files it rolled back. It stops at the first failure.
+func (m *Migrator) Rollback(ctx context.Context, opts RollbackOptions) ([]string, error) {
+	unlock, err := m.Lock(ctx, opts.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if !opts.Untracked {
+		if _, err := m.EnsureTrackingTable(ctx); err != nil {
+			return nil, err
+		}
+		if err := m.VerifyChecksums(ctx, opts.Repair); err != nil {
+			return nil, err
+		}
+	}
+
+	var files []string
+	if opts.File != "" {
+		files = []string{strings.TrimSuffix(opts.File, ".sql") + ".sql"}
+	} else {
+		steps := opts.Steps
+		if steps == 0 {
+			steps = 1
+		}
+		if files, err = m.RollbackTargets(ctx, steps, opts.To); err != nil {
+			return nil, err
+		}
+	}
+
+	var undone []string
+	for _, file := range files {
+		if err := m.RollbackFile(ctx, file, !opts.Untracked); err != nil {
+			return undone, fmt.Errorf("rollback %s: %w", file, err)
+		}
+		undone = append(undone, file)
+		if opts.OnRolledBack != nil {
+			if err := opts.OnRolledBack(file); err != nil {
+				return undone, err
+			}
+		}
+	}
+	return undone, nil
+}
+
+// Baseline adopts an existing database: it creates the tracking table and records every pending
+// migration up to and including opts.To as applied without running it. It returns the files it
+// recorded.
+func (m *Migrator) Baseline(ctx context.Context, opts BaselineOptions) ([]string, error) {
+	if opts.To == "" {
+		return nil, ErrNoBaselineTarget
+	}
+	unlock, err := m.Lock(ctx, opts.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := m.EnsureTrackingTable(ctx); err != nil {
+		return nil, err
+	}
+	files, err := m.Pending(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if files, err = UpTo(files, opts.To); err != nil {
+		return nil, err
+	}
+
+	var recorded []string
+	for _, file := range files {
+		if err := m.MarkBaselined(ctx, file); err != nil {
+			return recorded, err
+		}
+		recorded = append(recorded, file)
+	}
+	return recorded, nil
+}
+
+func (m *Migrator) logf(format string, v ...any) {
+	if m.Logf != nil {
+		m.Logf(format, v...)
+	}
+}
+
+// RepeatablePrefix marks repeatable migrations in _schema_migrations, e.g. repeatable/views.sql.
+const RepeatablePrefix = "repeatable/"
+
+// readFile reads a versioned migration, or a repeatable one when file has RepeatablePrefix.
+func (m *Migrator) readFile(file string) ([]byte, error) {
+	if name, ok := strings.CutPrefix(file, RepeatablePrefix); ok {
+		if m.Repeatable == nil {
+			return nil, fs.ErrNotExist
+		}
+		return fs.ReadFile(m.Repeatable, name)
+	}
+	return fs.ReadFile(m.Migrations, file)
+}
+
+// repeatableFiles lists the repeatable migrations with RepeatablePrefix, sorted by name.
+func (m *Migrator) repeatableFiles() ([]string, error) {
+	if m.Repeatable == nil {
+		return nil, nil
+	}
+	entries, err := fs.ReadDir(m.Repeatable, ".")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading repeatable migrations: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
+			files = append(files, RepeatablePrefix+entry.Name())
+		}
+	}
+	return files, nil
+}
+
+// trackingColumns lists the columns added to _schema_migrations after its original layout,
+// so existing tracking tables can be upgraded in place.
+func trackingColumns(dbtype string) []struct{ Name, Definition string } {
+	timestamp := "TIMESTAMP"
+	if dbtype == "mysql" || dbtype == "mariadb" {
+		timestamp = "DATETIME"
+	}
+	return []struct{ Name, Definition string }{
+		{"checksum", "VARCHAR(64)"},
+		{"applied_at", timestamp},
+		{"rolled_back_at", timestamp},
+		{"execution_ms", "BIGINT"},
+		{"version", "VARCHAR(64)"},
+		{"applied_by", "VARCHAR(255)"},
+		{"out_of_order", "BOOLEAN DEFAULT false"},
+		{"rolled_back_by", "VARCHAR(255)"},
+		{"rollback_ms", "BIGINT"},
+	}
+}
+
+// defaultActor identifies who applied or rolled back a migration as user@host.
+func defaultActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return name + "@" + host
+}
+
+// checksum returns the SHA-256 of a migration file, ignoring line ending differences.
+func checksum(content []byte) string {
+	normalized := strings.ReplaceAll(string(content), "\r\n", "\n")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// trackingTableColumns returns the lower-cased column names of _schema_migrations.
+func (m *Migrator) trackingTableColumns(ctx context.Context) (map[string]bool, error) {
+	cols, err := queryStrings(ctx, m.DB, m.dialect.ListTrackingCols)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s columns: %w", m.table, err)
+	}
+	existing := make(map[string]bool)
+	for _, c := range cols {
+		existing[strings.ToLower(c)] = true
+	}
+	return existing, nil
+}
+
+// upgradeTrackingTable adds any missing trackingColumns to an existing _schema_migrations table.
+func (m *Migrator) upgradeTrackingTable(ctx context.Context) error {
+	existing, err := m.trackingTableColumns(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, col := range trackingColumns(m.DBType) {
+		if existing[col.Name] {
+			continue
+		}
+		if _, err := m.DB.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, col.Name, col.Definition)); err != nil {
+			return fmt.Errorf("adding column '%s' to %s: %w", col.Name, m.table, err)
+		}
+	}
+	return nil
+}
+
+// EnsureTrackingTable creates the migrations table if it doesn't exist, running 0_init.sql from the
+// migrations when there is one and recording it as applied. Tracking tables created by older
+// versions are upgraded. It reports whether the table was created. A *TrackingTableMovedError is
+// returned when a renamed migrations table would start tracking from scratch.
+func (m *Migrator) EnsureTrackingTable(ctx context.Context) (bool, error) {
+	exists, err := m.trackingTableExists(ctx)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, m.upgradeTrackingTable(ctx)
+	}
+	if m.table != DefaultMigrationsTable {
+		var name string
+		err := m.DB.QueryRowContext(ctx, GetDialect(m.DBType).TableExists).Scan(&name)
+		if err == nil {
+			return false, &TrackingTableMovedError{Table: m.table, Existing: DefaultMigrationsTable}
+		}
+		if err != sql.ErrNoRows {
+			return false, fmt.Errorf("querying table existence: %w", err)
+		}
+	}
+
+	initSQL, err := fs.ReadFile(m.Migrations, "0_init.sql")
+	hasInit := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("reading 0_init.sql: %w", err)
+	}
+	if !hasInit {
+		initSQL = []byte(m.dialect.CreateInit)
+	}
+
+	if err := execStatements(ctx, m.DB, m.DBType, string(initSQL), "0_init.sql", 1); err != nil {
+		return false, fmt.Errorf("creating %s table: %w", m.table, err)
+	}
+	if exists, err = m.trackingTableExists(ctx); err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, fmt.Errorf("0_init.sql doesn't create %s, update it to the configured table name", m.table)
+	}
+	if err := m.upgradeTrackingTable(ctx); err != nil {
+		return false, err
+	}
+
+	if hasInit {
+		if _, err := m.DB.ExecContext(ctx, m.dialect.Insert, "0_init.sql", true); err != nil {
+			return false, fmt.Errorf("inserting 0_init.sql record: %w", err)
+		}
+		if _, err := m.DB.ExecContext(ctx, m.dialect.MarkApplied, checksum(initSQL), 0, m.Version, m.Actor, false, "0_init.sql"); err != nil {
+			return false, fmt.Errorf("recording checksum for 0_init.sql: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// VerifyChecksums compares every applied migration with its file and returns a *ChecksumError if
+// any file changed after it was run. With repair set, the current checksums are recorded instead.
+// Applied migrations recorded without a checksum get the current one, and those whose files are
+// gone are skipped.
+func (m *Migrator) VerifyChecksums(ctx context.Context, repair bool) error {
+	rows, err := m.DB.QueryContext(ctx, fmt.Sprintf("SELECT file, checksum FROM %s WHERE migrated = true AND file NOT LIKE 'repeatable/%%'", m.table))
+	if err != nil {
+		return fmt.Errorf("querying applied migrations: %w", err)
+	}
+	recorded := make(map[string]sql.NullString)
+	var files []string
+	for rows.Next() {
+		var file string
+		var sum sql.NullString
+		if err := rows.Scan(&file, &sum); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning applied migration: %w", err)
+		}
+		recorded[file] = sum
+		files = append(files, file)
+	}
+	rows.Close()
+
+	var mismatches []ChecksumMismatch
+	for _, file := range files {
+		content, err := fs.ReadFile(m.Migrations, file)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("reading %s: %w", file, err)
+		}
+
+		current := checksum(content)
+		sum := recorded[file]
+		if sum.Valid && sum.String == current {
+			continue
+		}
+
+		// Rows from tracking tables created before checksums have none, so the file as it is now
+		// becomes the baseline that later edits are detected against.
+		if repair || !sum.Valid {
+			if _, err := m.DB.ExecContext(ctx, m.dialect.UpdateChecksum, current, file); err != nil {
+				return fmt.Errorf("recording checksum for %s: %w", file, err)
+			}
+			if sum.Valid {
+				m.logf("Repaired checksum for %s", file)
+			} else {
+				m.logf("Recorded checksum for %s", file)
+			}
+			continue
+		}
+
+		mismatches = append(mismatches, ChecksumMismatch{File: file, Recorded: sum.String, Current: current})
+	}
+
+	if len(mismatches) > 0 {
+		return &ChecksumError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// migrationSection splits a migration file on "-- schema rollback" and returns the part before it,
+// or the part after it when rollback is set.
+func migrationSection(content []byte, rollback bool) (string, error) {
+	parts := strings.Split(string(content), "-- schema rollback")
+	if !rollback {
+		return parts[0], nil
+	}
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+		return "", ErrNoRollback
+	}
+	return parts[1], nil
+}
+
+// rollbackLine returns the line of content that the rollback section starts on.
+func rollbackLine(content []byte) int {
+	before, _, _ := strings.Cut(string(content), "-- schema rollback")
+	return strings.Count(before, "\n") + 1
+}
+
+// trackingTableExists reports whether _schema_migrations has been created.
+func (m *Migrator) trackingTableExists(ctx context.Context) (bool, error) {
+	var name string
+	err := m.DB.QueryRowContext(ctx, m.dialect.TableExists).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("querying table existence: %w", err)
+	}
+	return true, nil
+}
+
+// Pending returns the files Migrate would run, in version order: unmigrated rows of
+// _schema_migrations and files not tracked yet. With register set, those untracked files are
+// inserted into _schema_migrations first; without it the database is only read.
+func (m *Migrator) Pending(ctx context.Context, register bool) ([]string, error) {
+	entries, err := fs.ReadDir(m.Migrations, ".")
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations directory: %w", err)
+	}
+
+	exists, err := m.trackingTableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool)
+	var pending []string
+	if exists {
+		rows, err := m.DB.QueryContext(ctx, fmt.Sprintf("SELECT file, migrated FROM %s WHERE file NOT LIKE 'repeatable/%%' ORDER BY id ASC", m.table))
+		if err != nil {
+			return nil, fmt.Errorf("querying %s table: %w", m.table, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var file string
+			var migrated bool
+			if err := rows.Scan(&file, &migrated); err != nil {
+				return nil, fmt.Errorf("scanning migration file from DB: %w", err)
+			}
+			tracked[file] = true
+			if !migrated {
+				pending = append(pending, file)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		rows.Close()
+	}
+
+	var untracked []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".sql") || tracked[name] {
+			continue
+		}
+		// 0_init.sql is run and marked as migrated when the tracking table is created.
+		if !exists && name == "0_init.sql" {
+			continue
+		}
+		untracked = append(untracked, name)
+	}
+	// Registering in version order keeps the ids of new rows in that order too.
+	SortMigrations(untracked)
+	for _, name := range untracked {
+		if register {
+			if _, err := m.DB.ExecContext(ctx, m.dialect.Insert, name, false); err != nil {
+				m.logf("Warning: Could not add migration file '%s' to %s table: %v", name, m.table, err)
+				continue
+			}
+			m.logf("Added new migration file '%s' to %s table.", name, m.table)
+		}
+		pending = append(pending, name)
+	}
+	SortMigrations(pending)
+	return pending, nil
+}
+
+// PendingRepeatable returns the repeatable migrations that are new or changed since they last ran.
+func (m *Migrator) PendingRepeatable(ctx context.Context) ([]string, error) {
+	files, err := m.repeatableFiles()
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	recorded := make(map[string]string)
+	exists, err := m.trackingTableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		rows, err := m.DB.QueryContext(ctx, fmt.Sprintf("SELECT file, checksum FROM %s WHERE file LIKE 'repeatable/%%'", m.table))
+		if err != nil {
+			return nil, fmt.Errorf("querying repeatable migrations: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var file string
+			var sum sql.NullString
+			if err := rows.Scan(&file, &sum); err != nil {
+				return nil, fmt.Errorf("scanning repeatable migration: %w", err)
+			}
+			recorded[file] = sum.String
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var pending []string
+	for _, file := range files {
+		content, err := m.readFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", file, err)
+		}
+		if recorded[file] != checksum(content) {
+			pending = append(pending, file)
+		}
+	}
+	return pending, nil
+}
+
+// Plan concatenates the migration (or rollback) sections of files for a dry run.
+func (m *Migrator) Plan(files []string, rollback bool) (string, error) {
+	var sb strings.Builder
+	for _, file := range files {
+		content, err := m.readFile(file)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", file, err)
+		}
+		section, err := migrationSection(content, rollback)
+		if err != nil {
+			return "", fmt.Errorf("%w in %s", err, file)
+		}
+		if section, err = expandPlaceholders(section, file, m.DBType, m.Vars); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "-- %s\n%s\n\n", file, strings.TrimSpace(section))
+	}
+	return sb.String(), nil
+}
+
+// UpTo trims pending to the migrations up to and including to, which is a file name or a version
+// prefix such as 7 or 20261017T120000. An empty to keeps them all.
+func UpTo(pending []string, to string) ([]string, error) {
+	if to == "" {
+		return pending, nil
+	}
+	to = strings.TrimSuffix(to, ".sql")
+	end := -1
+	for i, file := range pending {
+		prefix, _, _ := strings.Cut(file, "_")
+		if file == to+".sql" || prefix == to {
+			end = i
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("'%s' is %w", to, ErrNotPending)
+	}
+	return pending[:end+1], nil
+}
+
+// OutOfOrder finds the pending migrations older than the latest applied one, which usually
+// means they were merged in from another branch. Unless allow is set they are refused with an
+// *OutOfOrderError.
+func (m *Migrator) OutOfOrder(ctx context.Context, pending []string, allow bool) (map[string]bool, error) {
+	exists, err := m.trackingTableExists(ctx)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, fmt.Sprintf("SELECT file FROM %s WHERE migrated = true AND file NOT LIKE 'repeatable/%%'", m.table))
+	if err != nil {
+		return nil, fmt.Errorf("querying %s table: %w", m.table, err)
+	}
+	defer rows.Close()
+
+	var latest string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, fmt.Errorf("scanning migration file from DB: %w", err)
+		}
+		if latest == "" || CompareMigrations(file, latest) > 0 {
+			latest = file
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	outOfOrder := make(map[string]bool)
+	var behind []string
+	for _, file := range pending {
+		if latest != "" && CompareMigrations(file, latest) < 0 {
+			outOfOrder[file] = true
+			behind = append(behind, file)
+		}
+	}
+	if len(behind) > 0 && !allow {
+		return nil, &OutOfOrderError{Latest: latest, Files: behind}
+	}
+	return outOfOrder, nil
+}
+
+// RollbackTargets lists the applied migrations to roll back, newest version first. With to set it returns
+// every migration applied after to, which itself stays applied; otherwise the last steps migrations.
+func (m *Migrator) RollbackTargets(ctx context.Context, steps int, to string) ([]string, error) {
+	exists, err := m.trackingTableExists(ctx)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, fmt.Sprintf("SELECT file FROM %s WHERE migrated = true AND file NOT LIKE 'repeatable/%%' ORDER BY id DESC", m.table))
+	if err != nil {
+		return nil, fmt.Errorf("querying %s table: %w", m.table, err)
+	}
+	defer rows.Close()
+
+	var applied []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, fmt.Errorf("scanning migration file from DB: %w", err)
+		}
+		applied = append(applied, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Migrations are applied in version order, which ids don't follow for files registered by
+	// older versions.
+	sort.SliceStable(applied, func(i, j int) bool { return CompareMigrations(applied[i], applied[j]) > 0 })
+
+	if to != "" {
+		to = strings.TrimSuffix(to, ".sql") + ".sql"
+		for i, file := range applied {
+			if file == to {
+				return applied[:i], nil
+			}
+		}
+		return nil, fmt.Errorf("'%s' is %w", to, ErrNotApplied)
+	}
+
+	if steps < 1 {
+		return nil, fmt.Errorf("steps must be at least 1, got %d", steps)
+	}
+	if steps > len(applied) {
+		steps = len(applied)
+	}
+	return applied[:steps], nil
+}
+
+// Apply runs the migration section of a file and records it as applied, together with its
+// checksum and whether it ran out of order, in a single transaction unless the file opts out
+// with the no-transaction directive. A file that isn't tracked yet, such as a new migration or a
+// repeatable migration on its first run, is inserted together with the record.
+func (m *Migrator) Apply(ctx context.Context, file string, outOfOrder bool) error {
+	content, err := m.readFile(file)
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+	migrationSQL, _ := migrationSection(content, false)
+
+	var migrated bool
+	err = m.DB.QueryRowContext(ctx, m.dialect.SelectStatus, file).Scan(&migrated)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("querying migration status: %w", err)
+	}
+	untracked := err == sql.ErrNoRows
+
+	return m.run(ctx, file, content, migrationSQL, 1, func(ex execer, elapsed int64) error {
+		if untracked {
+			if _, err := ex.ExecContext(ctx, m.dialect.Insert, file, false); err != nil {
+				return err
+			}
+		}
+		_, err := ex.ExecContext(ctx, m.dialect.MarkApplied, checksum(content), elapsed, m.Version, m.Actor, outOfOrder, file)
+		return err
+	})
+}
+
+// MarkBaselined records a tracked migration as applied, with its current checksum, without
+// running it.
+func (m *Migrator) MarkBaselined(ctx context.Context, file string) error {
+	content, err := m.readFile(file)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", file, err)
+	}
+	if _, err := m.DB.ExecContext(ctx, m.dialect.MarkApplied, checksum(content), 0, m.Version, m.Actor, false, file); err != nil {
+		return fmt.Errorf("marking %s as applied: %w", file, err)
+	}
+	return nil
+}
+
+// RollbackFile runs the rollback section of a file. When track is set the migration is also
+// marked as rolled back in _schema_migrations, in the same transaction.
+func (m *Migrator) RollbackFile(ctx context.Context, file string, track bool) error {
+	content, err := m.readFile(file)
+	if err != nil {
+		return fmt.Errorf("reading SQL file for rollback: %w", err)
+	}
+
+	rollbackSQL, err := migrationSection(content, true)
+	if err != nil {
+		return fmt.Errorf("%w in %s", err, file)
+	}
+
+	return m.run(ctx, file, content, rollbackSQL, rollbackLine(content), func(ex execer, elapsed int64) error {
+		if !track {
+			return nil
+		}
+		_, err := ex.ExecContext(ctx, m.dialect.MarkRolledBack, elapsed, m.Actor, file)
+		return err
+	})
+}
+
+// RedoFile rolls back an applied migration and applies it again from its current contents. Both
+// happen in one transaction unless the database is MySQL or MariaDB, where DDL commits implicitly,
+// or the file has the no-transaction directive. A file without a rollback section is left alone.
+func (m *Migrator) RedoFile(ctx context.Context, file string) error {
+	content, err := m.readFile(file)
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+	rollbackSQL, err := migrationSection(content, true)
+	if err != nil {
+		return fmt.Errorf("%w in %s", err, file)
+	}
+	status, err := m.migrationStatus(ctx, []string{file})
+	if err != nil {
+		return err
+	}
+	if !status[file] {
+		return fmt.Errorf("'%s' is %w", file, ErrNotApplied)
+	}
+	// A file first applied out of order stays recorded that way.
+	var outOfOrder sql.NullBool
+	if err := m.DB.QueryRowContext(ctx, m.dialect.SelectOutOfOrder, file).Scan(&outOfOrder); err != nil {
+		return fmt.Errorf("querying migration status of %s: %w", file, err)
+	}
+
+	if m.DBType == "mysql" || m.DBType == "mariadb" || isNoTransaction(content) {
+		if err := m.RollbackFile(ctx, file, true); err != nil {
+			return err
+		}
+		return m.Apply(ctx, file, outOfOrder.Bool)
+	}
+
+	migrationSQL, _ := migrationSection(content, false)
+	if migrationSQL, err = expandPlaceholders(migrationSQL, file, m.DBType, m.Vars); err != nil {
+		return err
+	}
+	return m.run(ctx, file, content, rollbackSQL, rollbackLine(content), func(ex execer, elapsed int64) error {
+		if _, err := ex.ExecContext(ctx, m.dialect.MarkRolledBack, elapsed, m.Actor, file); err != nil {
+			return err
+		}
+		start := time.Now()
+		if err := execStatements(ctx, ex, m.DBType, migrationSQL, file, 1); err != nil {
+			return err
+		}
+		_, err := ex.ExecContext(ctx, m.dialect.MarkApplied, checksum(content), time.Since(start).Milliseconds(), m.Version, m.Actor, outOfOrder.Bool, file)
+		return err
+	})
+}
+
+// run executes script, the section of file starting at firstLine, followed by record, which
+// updates _schema_migrations. Both share a transaction unless the file has the no-transaction
+// directive. Placeholders in script are expanded first.
+func (m *Migrator) run(ctx context.Context, file string, content []byte, script string, firstLine int, record func(ex execer, elapsed int64) error) error {
+	script, err := expandPlaceholders(script, file, m.DBType, m.Vars)
+	if err != nil {
+		return err
+	}
+
+	isSQLite := m.DBType == "sqlite" || m.DBType == "libsql" || m.DBType == "turso" || m.DBType == "tursosync"
+	if isSQLite {
+		_, _ = m.DB.ExecContext(ctx, "PRAGMA foreign_keys=OFF;")
+	}
+
+	noTx := isNoTransaction(content)
+	var ex execer = m.DB
+	var tx *sql.Tx
+	if !noTx {
+		tx, err = m.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("starting transaction: %w", err)
+		}
+		defer tx.Rollback()
+		ex = tx
+	}
+
+	start := time.Now()
+	if err := execStatements(ctx, ex, m.DBType, script, file, firstLine); err != nil {
+		var stmtErr *StatementError
+		if errors.As(err, &stmtErr) {
+			stmtErr.NoTransaction = noTx
+		}
+		return err
+	}
+
+	if err := record(ex, time.Since(start).Milliseconds()); err != nil {
+		var stmtErr *StatementError
+		if errors.As(err, &stmtErr) {
+			stmtErr.NoTransaction = noTx
+			return err
+		}
+		return fmt.Errorf("updating migration status: %w", err)
+	}
+
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("committing transaction: %w", err)
+		}
+	}
+
+	if isSQLite {
+		_, _ = m.DB.ExecContext(ctx, "PRAGMA foreign_keys=ON;")
+	}
+	return nil
+}
+
+const (
+	StatusApplied   = "applied"
+	StatusModified  = "modified"
+	StatusPending   = "pending"
+	StatusMissing   = "missing"
+	StatusUntracked = "untracked"
+)
+
+// State is the status of one migration file, as listed by `schema status`.
+type State struct {
+	File      string
+	Status    string
+	AppliedAt string
+}
+
+// Status merges the migration files with the rows of _schema_migrations, in version order. It
+// never writes to the database.
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	onDisk := make(map[string]bool)
+	var diskFiles []string
+	entries, err := fs.ReadDir(m.Migrations, ".")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading migrations directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
+			onDisk[entry.Name()] = true
+			diskFiles = append(diskFiles, entry.Name())
+		}
+	}
+	repeatable, err := m.repeatableFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range repeatable {
+		onDisk[file] = true
+		diskFiles = append(diskFiles, file)
+	}
+
+	var states []State
+	tracked := make(map[string]bool)
+
+	exists, err := m.trackingTableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		cols, err := m.trackingTableColumns(ctx)
+		if err != nil {
+			return nil, err
+		}
+		optional := []string{"checksum", "applied_at"}
+		for i, col := range optional {
+			if !cols[col] {
+				optional[i] = "NULL"
+			}
+		}
+
+		rows, err := m.DB.QueryContext(ctx, fmt.Sprintf("SELECT file, migrated, %s FROM %s ORDER BY id ASC", strings.Join(optional, ", "), m.table))
+		if err != nil {
+			return nil, fmt.Errorf("querying %s: %w", m.table, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var file string
+			var migrated bool
+			var sum, appliedAt sql.NullString
+			if err := rows.Scan(&file, &migrated, &sum, &appliedAt); err != nil {
+				return nil, fmt.Errorf("scanning %s: %w", m.table, err)
+			}
+			tracked[file] = true
+
+			state := State{File: file, Status: StatusPending}
+			if migrated {
+				state.AppliedAt = appliedAt.String
+			}
+			switch {
+			case !onDisk[file]:
+				state.Status = StatusMissing
+			case migrated:
+				state.Status = StatusApplied
+				if sum.Valid {
+					content, err := m.readFile(file)
+					if err != nil {
+						return nil, fmt.Errorf("reading %s: %w", file, err)
+					}
+					if checksum(content) != sum.String {
+						// A changed repeatable migration is simply due to run again.
+						state.Status = StatusModified
+						if strings.HasPrefix(file, RepeatablePrefix) {
+							state.Status = StatusPending
+						}
+					}
+				}
+			}
+			states = append(states, state)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range diskFiles {
+		if !tracked[file] {
+			status := StatusUntracked
+			if strings.HasPrefix(file, RepeatablePrefix) {
+				status = StatusPending
+			}
+			states = append(states, State{File: file, Status: status})
+		}
+	}
+	sort.SliceStable(states, func(i, j int) bool { return CompareMigrations(states[i].File, states[j].File) < 0 })
+	return states, nil
+}
@@ -0,0 +1,170 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestMigrator returns a Migrator for a fresh SQLite database with files as its migrations.
+func newTestMigrator(t *testing.T, files fstest.MapFS) *Migrator {
+	t.Helper()
+	db, err := Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := New(db, "sqlite", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// tableMigrations returns migrations 1_t1.sql to n_tn.sql, each creating one table.
+func tableMigrations(n int) fstest.MapFS {
+	files := fstest.MapFS{}
+	for i := 1; i <= n; i++ {
+		files[fmt.Sprintf("%d_t%d.sql", i, i)] = &fstest.MapFile{
+			Data: fmt.Appendf(nil, "CREATE TABLE t%d (id INTEGER);\n-- schema rollback\nDROP TABLE t%d;\n", i, i),
+		}
+	}
+	return files
+}
+
+func TestRollbackTargetsFollowVersionOrder(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t, tableMigrations(11))
+	if _, err := m.Migrate(ctx, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := queryStrings(ctx, m.DB, "SELECT file FROM "+m.table+" ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1_t1.sql", "2_t2.sql", "3_t3.sql", "4_t4.sql", "5_t5.sql", "6_t6.sql", "7_t7.sql", "8_t8.sql", "9_t9.sql", "10_t10.sql", "11_t11.sql"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("tracked files by id = %v, want %v", files, want)
+	}
+
+	tests := []struct {
+		steps int
+		to    string
+		want  []string
+	}{
+		{steps: 1, want: []string{"11_t11.sql"}},
+		{steps: 3, want: []string{"11_t11.sql", "10_t10.sql", "9_t9.sql"}},
+		{to: "9_t9", want: []string{"11_t11.sql", "10_t10.sql"}},
+		{to: "10_t10.sql", want: []string{"11_t11.sql"}},
+	}
+	for _, tt := range tests {
+		got, err := m.RollbackTargets(ctx, tt.steps, tt.to)
+		if err != nil {
+			t.Fatalf("RollbackTargets(%d, %q): %v", tt.steps, tt.to, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RollbackTargets(%d, %q) = %v, want %v", tt.steps, tt.to, got, tt.want)
+		}
+	}
+
+	undone, err := m.Rollback(ctx, RollbackOptions{Steps: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"11_t11.sql", "10_t10.sql"}; !reflect.DeepEqual(undone, want) {
+		t.Errorf("Rollback = %v, want %v", undone, want)
+	}
+}
+
+func TestVerifyChecksumsBackfillsMissingChecksums(t *testing.T) {
+	ctx := context.Background()
+	files := tableMigrations(2)
+	m := newTestMigrator(t, files)
+	if _, err := m.Migrate(ctx, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Rows of tracking tables created before checksums were recorded have none.
+	if _, err := m.DB.ExecContext(ctx, "UPDATE "+m.table+" SET checksum = NULL"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.VerifyChecksums(ctx, false); err != nil {
+		t.Fatalf("VerifyChecksums with missing checksums: %v", err)
+	}
+	var missing int
+	if err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+m.table+" WHERE checksum IS NULL").Scan(&missing); err != nil {
+		t.Fatal(err)
+	}
+	if missing != 0 {
+		t.Errorf("%d rows still have no checksum", missing)
+	}
+
+	files["2_t2.sql"].Data = append(files["2_t2.sql"].Data, "-- edited\n"...)
+	var checksumErr *ChecksumError
+	if err := m.VerifyChecksums(ctx, false); !errors.As(err, &checksumErr) || len(checksumErr.Mismatches) != 1 || checksumErr.Mismatches[0].File != "2_t2.sql" {
+		t.Errorf("VerifyChecksums after an edit = %v, want a *ChecksumError for 2_t2.sql", err)
+	}
+}
+
+func TestRollbackKeepsAppliedDetails(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t, tableMigrations(1))
+	m.Actor, m.Version = "deployer@ci", "1.0.0"
+	if _, err := m.Migrate(ctx, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m.Actor, m.Version = "oncall@laptop", "1.0.1"
+	if _, err := m.Rollback(ctx, RollbackOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var appliedBy, version, rolledBackBy string
+	err := m.DB.QueryRowContext(ctx, "SELECT applied_by, version, rolled_back_by FROM "+m.table+" WHERE file = '1_t1.sql'").Scan(&appliedBy, &version, &rolledBackBy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appliedBy != "deployer@ci" || version != "1.0.0" || rolledBackBy != "oncall@laptop" {
+		t.Errorf("applied_by, version, rolled_back_by = %s, %s, %s, want deployer@ci, 1.0.0, oncall@laptop", appliedBy, version, rolledBackBy)
+	}
+}
+
+func TestRefusedMigrateLeavesNoRows(t *testing.T) {
+	ctx := context.Background()
+	files := tableMigrations(3)
+	late := files["2_t2.sql"]
+	delete(files, "2_t2.sql")
+	m := newTestMigrator(t, files)
+	if _, err := m.Migrate(ctx, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	files["2_t2.sql"] = late
+	var orderErr *OutOfOrderError
+	if _, err := m.Migrate(ctx, MigrateOptions{}); !errors.As(err, &orderErr) {
+		t.Fatalf("Migrate = %v, want an *OutOfOrderError", err)
+	}
+	tracked := func() []string {
+		files, err := queryStrings(ctx, m.DB, "SELECT file FROM "+m.table+" ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	if got, want := tracked(), []string{"1_t1.sql", "3_t3.sql"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tracked after a refused migrate = %v, want %v", got, want)
+	}
+
+	if _, err := m.Migrate(ctx, MigrateOptions{AllowOutOfOrder: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tracked(), []string{"1_t1.sql", "3_t3.sql", "2_t2.sql"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tracked after migrating out of order = %v, want %v", got, want)
+	}
+}
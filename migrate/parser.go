@@ -1,4 +1,4 @@
-package main
+package migrate
 
 import (
 	"os"
@@ -17,7 +17,7 @@ func ParseSchemaFile(filePath string) (*Database, error) {
 	strContent := string(content)
 
 	// 1. Parse Enums (Updated regex to handle spaces before the closing parenthesis)
-	enumRe := regexp.MustCompile(`(?m)^enum\s+([a-zA-Z0-9_]+)\s*\(([\s\S]*?)\n\s*\)`)
+	enumRe := regexp.MustCompile(`(?m)^enum\s+([a-zA-Z0-9_.]+)\s*\(([\s\S]*?)\n\s*\)`)
 	enumMatches := enumRe.FindAllStringSubmatch(strContent, -1)
 	for _, m := range enumMatches {
 		enumName := m[1]
@@ -35,7 +35,7 @@ func ParseSchemaFile(filePath string) (*Database, error) {
 	}
 
 	// 2. Parse Tables (Updated regex to handle spaces/carriage returns before closing parenthesis)
-	tableRe := regexp.MustCompile(`(?mi)^table\s+([a-zA-Z0-9_]+)(?:\s+FROM\s+([a-zA-Z0-9_]+))?\s*\(([\s\S]*?)\n\s*\)`)
+	tableRe := regexp.MustCompile(`(?mi)^table\s+([a-zA-Z0-9_.]+)(?:\s+FROM\s+([a-zA-Z0-9_.]+))?\s*\(([\s\S]*?)\n\s*\)`)
 	tableMatches := tableRe.FindAllStringSubmatch(strContent, -1)
 
 	for _, m := range tableMatches {
@@ -181,7 +181,7 @@ func parseInlineFK(line, colName string) Constraint {
 		Columns: []string{colName},
 	}
 
-	re := regexp.MustCompile(`(?i)REFERENCES\s+([a-zA-Z0-9_]+)\s*\((.*?)\)`)
+	re := regexp.MustCompile(`(?i)REFERENCES\s+([a-zA-Z0-9_.]+)\s*\((.*?)\)`)
 	if m := re.FindStringSubmatch(line); len(m) > 2 {
 		c.ReferenceTable = m[1]
 		c.ReferenceColumns = []string{strings.TrimSpace(m[2])}
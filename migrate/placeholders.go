@@ -0,0 +1,57 @@
+package migrate
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+var placeholderRegex = regexp.MustCompile(`\$?\$\{(env:)?([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandPlaceholders replaces ${name} with vars[name] and ${env:NAME} with the environment
+// variable NAME, and $${name} with a literal ${name}. Comments are left as they are, so a
+// commented out statement can keep a placeholder that no longer has a value. Placeholders
+// without a value are returned as a *PlaceholderError.
+func expandPlaceholders(script, file, dbtype string, vars map[string]string) (string, error) {
+	var missing []string
+	seen := make(map[string]bool)
+	expand := func(code string) string {
+		return placeholderRegex.ReplaceAllStringFunc(code, func(match string) string {
+			if strings.HasPrefix(match, "$$") {
+				return match[1:]
+			}
+			parts := placeholderRegex.FindStringSubmatch(match)
+			var value string
+			var ok bool
+			if parts[1] != "" {
+				value, ok = os.LookupEnv(parts[2])
+			} else {
+				value, ok = vars[parts[2]]
+			}
+			if !ok {
+				if !seen[match] {
+					seen[match] = true
+					missing = append(missing, match)
+				}
+				return match
+			}
+			return value
+		})
+	}
+
+	var sb strings.Builder
+	code := 0
+	scanSQL(script, dbtype, func(kind tokenKind, from, to int) {
+		if kind == tokenComment {
+			sb.WriteString(expand(script[code:from]))
+			sb.WriteString(script[from:to])
+			code = to
+		}
+	})
+	sb.WriteString(expand(script[code:]))
+
+	if len(missing) > 0 {
+		return "", &PlaceholderError{File: file, Placeholders: missing}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestExpandPlaceholders(t *testing.T) {
+	t.Setenv("SCHEMA_TEST_ROLE", "reader")
+	vars := map[string]string{"schema": "tenant_a", "empty": ""}
+	tests := []struct {
+		name, dbType, script, want string
+	}{
+		{"vars", "postgres", "CREATE TABLE ${schema}.orders (id INT);", "CREATE TABLE tenant_a.orders (id INT);"},
+		{"env", "postgres", "GRANT SELECT ON t TO ${env:SCHEMA_TEST_ROLE};", "GRANT SELECT ON t TO reader;"},
+		{"empty value", "postgres", "SELECT '${empty}';", "SELECT '';"},
+		{"strings are expanded", "postgres", "COMMENT ON TABLE t IS 'owned by ${schema}';", "COMMENT ON TABLE t IS 'owned by tenant_a';"},
+		{"line comments are left alone", "postgres", "-- uses ${missing}\nSELECT 1; -- and ${schema}", "-- uses ${missing}\nSELECT 1; -- and ${schema}"},
+		{"block comments are left alone", "postgres", "/* ${missing} */ SELECT '${schema}';", "/* ${missing} */ SELECT 'tenant_a';"},
+		{"mysql hash comments are left alone", "mysql", "# ${missing}\nSELECT '${schema}';", "# ${missing}\nSELECT 'tenant_a';"},
+		{"escaped", "postgres", "SELECT '$${schema}', '$${missing}';", "SELECT '${schema}', '${missing}';"},
+		{"not a placeholder", "postgres", "SELECT '$1', '${1x}', '$ {schema}';", "SELECT '$1', '${1x}', '$ {schema}';"},
+		{"dollar quotes", "postgres", "CREATE FUNCTION f() RETURNS text AS $$ SELECT '${schema}' $$ LANGUAGE sql;", "CREATE FUNCTION f() RETURNS text AS $$ SELECT 'tenant_a' $$ LANGUAGE sql;"},
+	}
+	for _, tt := range tests {
+		got, err := expandPlaceholders(tt.script, "1_test.sql", tt.dbType, vars)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandPlaceholdersUnresolved(t *testing.T) {
+	script := "CREATE TABLE ${schema}.a (id INT);\n-- ${commented}\nCREATE TABLE ${schema}.b (r TEXT DEFAULT '${env:SCHEMA_TEST_UNSET}');"
+	_, err := expandPlaceholders(script, "2_test.sql", "postgres", nil)
+	var placeholderErr *PlaceholderError
+	if !errors.As(err, &placeholderErr) {
+		t.Fatalf("err = %v, want a *PlaceholderError", err)
+	}
+	if placeholderErr.File != "2_test.sql" {
+		t.Errorf("File = %s, want 2_test.sql", placeholderErr.File)
+	}
+	if want := []string{"${schema}", "${env:SCHEMA_TEST_UNSET}"}; !reflect.DeepEqual(placeholderErr.Placeholders, want) {
+		t.Errorf("Placeholders = %v, want %v", placeholderErr.Placeholders, want)
+	}
+}
@@ -0,0 +1,29 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ShadowSchema replays every migration into shadow, an empty database of the same type, and
+// returns the schema they produce. Unlike inspecting m.DB, the result leaves out any changes that
+// were made to the database by hand.
+func (m *Migrator) ShadowSchema(ctx context.Context, shadow *sql.DB) (*Database, error) {
+	if err := m.ensureEmpty(ctx, shadow); err != nil {
+		return nil, err
+	}
+
+	replay := *m
+	replay.DB = shadow
+	replay.Logf = nil
+	if _, err := replay.Migrate(ctx, MigrateOptions{AllowOutOfOrder: true}); err != nil {
+		return nil, fmt.Errorf("replaying migrations on the shadow database: %w", err)
+	}
+
+	schema, err := m.config.InspectSchema(ctx, shadow, m.DBType)
+	if err != nil {
+		return nil, fmt.Errorf("inspecting shadow database: %w", err)
+	}
+	return schema, nil
+}
@@ -0,0 +1,364 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// NoTransactionDirective in a migration's leading comments makes it run statement by statement
+// outside a transaction, for statements such as CREATE INDEX CONCURRENTLY.
+const NoTransactionDirective = "-- schema no-transaction"
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// sqlStatement is a single statement of a script and the 1-based line it starts on.
+type sqlStatement struct {
+	Text string
+	Line int
+}
+
+// isNoTransaction reports whether the comment lines at the top of a migration file contain the
+// no-transaction directive.
+func isNoTransaction(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			return false
+		}
+		if strings.EqualFold(line, NoTransactionDirective) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenKind classifies the tokens of a script for scanSQL.
+type tokenKind int
+
+const (
+	// tokenOther is a single character that isn't part of any other token.
+	tokenOther tokenKind = iota
+	tokenWord
+	// tokenQuoted is a quoted string or identifier, a Postgres dollar-quoted body or a MySQL
+	// /*! ... */ comment, whose contents run but never end a statement.
+	tokenQuoted
+	// tokenComment is a -- line comment, a MySQL # comment or a /* ... */ block comment.
+	tokenComment
+	tokenSemicolon
+)
+
+// scanSQL walks script and calls emit with the kind and byte range of each of its tokens.
+func scanSQL(script, dbtype string, emit func(kind tokenKind, from, to int)) {
+	isMySQL := dbtype == "mysql" || dbtype == "mariadb"
+
+	for i := 0; i < len(script); {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			backslash := isMySQL && c != '`' || dbtype == "postgres" && c == '\'' && isEscapeString(script, i)
+			end := i + 1
+			for end < len(script) {
+				if backslash && script[end] == '\\' {
+					end += 2
+					continue
+				}
+				if script[end] == c {
+					if end+1 < len(script) && script[end+1] == c {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			end = min(end+1, len(script))
+			emit(tokenQuoted, i, end)
+			i = end
+
+		case c == '-' && i+1 < len(script) && script[i+1] == '-', c == '#' && isMySQL:
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			emit(tokenComment, i, i+end)
+			i += end
+
+		case c == '/' && i+1 < len(script) && script[i+1] == '*':
+			end := len(script)
+			if n := strings.Index(script[i+2:], "*/"); n >= 0 {
+				end = i + 2 + n + 2
+			}
+			// MySQL runs the contents of /*! ... */ comments.
+			if isMySQL && strings.HasPrefix(script[i:], "/*!") {
+				emit(tokenQuoted, i, end)
+			} else {
+				emit(tokenComment, i, end)
+			}
+			i = end
+
+		case c == '$' && dbtype == "postgres" && dollarTag(script[i:]) != "":
+			tag := dollarTag(script[i:])
+			end := len(script)
+			if n := strings.Index(script[i+len(tag):], tag); n >= 0 {
+				end = i + len(tag) + n + len(tag)
+			}
+			emit(tokenQuoted, i, end)
+			i = end
+
+		case c == ';':
+			emit(tokenSemicolon, i, i+1)
+			i++
+
+		case isWordStart(script, i):
+			end := i
+			for end < len(script) && isWordChar(script[end]) {
+				end++
+			}
+			emit(tokenWord, i, end)
+			i = end
+
+		default:
+			emit(tokenOther, i, i+1)
+			i++
+		}
+	}
+}
+
+// splitSQL splits a script into statements on semicolons. Semicolons inside quotes, comments,
+// Postgres dollar-quoted bodies and BEGIN...END blocks of triggers and routines don't end a
+// statement. Comments before a statement are dropped.
+func splitSQL(script, dbtype string) []sqlStatement {
+	var stmts []sqlStatement
+	var sb strings.Builder
+	line, start := 1, 0
+	depth := 0
+
+	flush := func() {
+		if text := strings.TrimSpace(sb.String()); text != "" {
+			stmts = append(stmts, sqlStatement{Text: text, Line: start})
+		}
+		sb.Reset()
+		start, depth = 0, 0
+	}
+	// write copies script[from:to] into the current statement, keeping line in step.
+	write := func(from, to int) {
+		chunk := script[from:to]
+		if start == 0 && strings.TrimSpace(chunk) != "" {
+			start = line + strings.Count(chunk[:len(chunk)-len(strings.TrimLeft(chunk, " \t\r\n"))], "\n")
+		}
+		if start != 0 {
+			sb.WriteString(chunk)
+		}
+		line += strings.Count(chunk, "\n")
+	}
+
+	scanSQL(script, dbtype, func(kind tokenKind, from, to int) {
+		switch kind {
+		case tokenComment:
+			if start != 0 {
+				write(from, to)
+			} else {
+				line += strings.Count(script[from:to], "\n")
+			}
+		case tokenSemicolon:
+			if depth == 0 {
+				flush()
+			} else {
+				write(from, to)
+			}
+		case tokenWord:
+			depth += blockDelta(strings.ToUpper(script[from:to]), strings.ToUpper(sb.String()), script[to:], depth)
+			write(from, to)
+		default:
+			write(from, to)
+		}
+	})
+	flush()
+	return stmts
+}
+
+// readStatements are the statements IsReadOnly accepts, provided they contain no writeWords.
+var readStatements = map[string]bool{"SELECT": true, "WITH": true, "VALUES": true, "SHOW": true, "EXPLAIN": true, "DESCRIBE": true, "DESC": true}
+
+// writeWords make a statement a write wherever they appear in it, such as SELECT ... INTO.
+var writeWords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "REPLACE": true, "UPSERT": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "GRANT": true, "REVOKE": true,
+	"INTO": true, "COPY": true, "CALL": true, "EXECUTE": true, "LOCK": true,
+}
+
+// parenKeywords are the keywords that may be followed by a parenthesis without calling a
+// function, such as IN (...) and subqueries.
+var parenKeywords = map[string]bool{
+	"SELECT": true, "FROM": true, "JOIN": true, "ON": true, "USING": true, "WHERE": true, "AND": true,
+	"OR": true, "NOT": true, "IN": true, "EXISTS": true, "ANY": true, "ALL": true, "SOME": true,
+	"AS": true, "OVER": true, "FILTER": true, "WITHIN": true, "VALUES": true, "LATERAL": true,
+	"UNION": true, "INTERSECT": true, "EXCEPT": true, "WHEN": true, "THEN": true, "ELSE": true,
+	"CASE": true, "IS": true, "LIKE": true, "BETWEEN": true, "BY": true, "GROUP": true, "HAVING": true,
+	"LIMIT": true, "OFFSET": true, "DISTINCT": true, "ROW": true, "ARRAY": true, "EXPLAIN": true,
+}
+
+// readOnlyFunctions are the built-in functions and type names IsReadOnly lets a read call.
+var readOnlyFunctions = map[string]bool{
+	"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true, "ARRAY_AGG": true,
+	"STRING_AGG": true, "GROUP_CONCAT": true, "JSON_AGG": true, "JSONB_AGG": true,
+	"ROW_NUMBER": true, "RANK": true, "DENSE_RANK": true, "NTILE": true, "LAG": true, "LEAD": true,
+	"FIRST_VALUE": true, "LAST_VALUE": true, "COALESCE": true, "NULLIF": true, "IFNULL": true,
+	"IIF": true, "IF": true, "GREATEST": true, "LEAST": true, "CAST": true, "TYPEOF": true,
+	"LOWER": true, "UPPER": true, "LENGTH": true, "CHAR_LENGTH": true, "OCTET_LENGTH": true,
+	"SUBSTR": true, "SUBSTRING": true, "TRIM": true, "LTRIM": true, "RTRIM": true, "CONCAT": true,
+	"CONCAT_WS": true, "POSITION": true, "INSTR": true, "STRPOS": true, "LEFT": true, "RIGHT": true,
+	"LPAD": true, "RPAD": true, "SPLIT_PART": true, "FORMAT": true, "PRINTF": true, "ROUND": true,
+	"FLOOR": true, "CEIL": true, "CEILING": true, "ABS": true, "MOD": true, "POWER": true,
+	"SQRT": true, "NOW": true, "DATE": true, "TIME": true, "DATETIME": true, "STRFTIME": true,
+	"JULIANDAY": true, "UNIXEPOCH": true, "DATE_TRUNC": true, "DATE_PART": true, "EXTRACT": true,
+	"AGE": true, "TO_CHAR": true, "TO_DATE": true, "TO_TIMESTAMP": true, "DATE_FORMAT": true,
+	"STR_TO_DATE": true, "JSON_EXTRACT": true, "JSON_OBJECT": true, "JSON_ARRAY": true,
+	"JSON_BUILD_OBJECT": true, "JSONB_BUILD_OBJECT": true, "GENERATE_SERIES": true, "UNNEST": true,
+	"VERSION": true, "DATABASE": true, "CURRENT_USER": true, "CURRENT_SETTING": true,
+	"PG_SIZE_PRETTY": true, "PG_RELATION_SIZE": true, "PG_TOTAL_RELATION_SIZE": true,
+	"PG_DATABASE_SIZE": true, "VARCHAR": true, "CHAR": true, "NUMERIC": true, "DECIMAL": true,
+}
+
+// IsReadOnly reports whether every statement of script only reads. It fails closed: a statement
+// only counts as a read when it starts with SELECT, WITH, VALUES, SHOW, EXPLAIN or DESCRIBE, has
+// none of the words that write, and calls no functions other than the built-ins known to only
+// read. Any other call, including schema-qualified and user-defined functions, counts as a write,
+// since it may have side effects. Strings and comments are ignored.
+func IsReadOnly(script, dbType string) bool {
+	readOnly := true
+	first := ""
+	// callee is the word or quoted identifier before a possible call, while only whitespace and
+	// comments follow it, and qualified reports whether a dot preceded it.
+	callee, qualified, dot := "", false, false
+	scanSQL(script, dbType, func(kind tokenKind, from, to int) {
+		switch kind {
+		case tokenSemicolon:
+			first, callee, dot = "", "", false
+		case tokenWord:
+			word := strings.ToUpper(script[from:to])
+			if first == "" {
+				first = word
+				if !readStatements[word] {
+					readOnly = false
+				}
+			}
+			if writeWords[word] {
+				readOnly = false
+			}
+			callee, qualified, dot = word, dot, false
+		case tokenQuoted:
+			// MySQL runs the contents of /*! ... */ comments.
+			if strings.HasPrefix(script[from:to], "/*!") {
+				readOnly = false
+			}
+			// A quoted identifier can name a function too, and never matches a known one.
+			callee, qualified, dot = script[from:to], dot, false
+		case tokenOther:
+			c := script[from:to]
+			if strings.TrimSpace(c) == "" {
+				return
+			}
+			// A statement starting with anything but a word, such as a parenthesis, is not
+			// recognised.
+			if first == "" {
+				readOnly = false
+			}
+			if c == "(" && callee != "" && (qualified || !readOnlyFunctions[callee] && !parenKeywords[callee]) {
+				readOnly = false
+			}
+			callee, dot = "", c == "."
+		}
+	})
+	return readOnly
+}
+
+// dollarTag returns the opening $tag$ at the start of s, or "" if s doesn't start with one.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '$' {
+			return s[:i+1]
+		}
+		if !isWordChar(s[i]) || (i == 1 && s[i] >= '0' && s[i] <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+// isEscapeString reports whether the quote at i opens a Postgres E'...' string, in which a
+// backslash escapes the next character.
+func isEscapeString(script string, i int) bool {
+	return i > 0 && (script[i-1] == 'E' || script[i-1] == 'e') && (i == 1 || !isWordChar(script[i-2]))
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isWordStart(script string, i int) bool {
+	return isWordChar(script[i]) && (i == 0 || !isWordChar(script[i-1]) && script[i-1] != '$')
+}
+
+// blockDelta tracks BEGIN...END nesting for a keyword given the upper-cased statement so far.
+// Blocks only open in CREATE TRIGGER, PROCEDURE, FUNCTION or EVENT statements, so a plain BEGIN
+// that starts a transaction is left alone.
+func blockDelta(word, stmt, rest string, depth int) int {
+	switch word {
+	case "BEGIN":
+		if depth > 0 || isRoutineDefinition(stmt) {
+			return 1
+		}
+	case "CASE":
+		// END CASE closes a MySQL CASE statement rather than opening another one.
+		fields := strings.Fields(stmt)
+		if depth > 0 && (len(fields) == 0 || fields[len(fields)-1] != "END") {
+			return 1
+		}
+	case "END":
+		if depth == 0 {
+			return 0
+		}
+		next := strings.ToUpper(strings.TrimSpace(rest))
+		for _, kw := range []string{"IF", "LOOP", "WHILE", "REPEAT"} {
+			if strings.HasPrefix(next, kw) && (len(next) == len(kw) || !isWordChar(next[len(kw)])) {
+				return 0
+			}
+		}
+		return -1
+	}
+	return 0
+}
+
+// isRoutineDefinition reports whether stmt starts a CREATE TRIGGER, PROCEDURE, FUNCTION or EVENT.
+func isRoutineDefinition(stmt string) bool {
+	fields := strings.Fields(stmt)
+	if len(fields) == 0 || fields[0] != "CREATE" {
+		return false
+	}
+	for i := 1; i < len(fields) && i < 6; i++ {
+		switch fields[i] {
+		case "TRIGGER", "PROCEDURE", "FUNCTION", "EVENT":
+			return true
+		}
+	}
+	return false
+}
+
+// execStatements runs each statement of script on its own, reporting the file and line of a
+// failing statement. firstLine is the line of file that script starts on.
+func execStatements(ctx context.Context, ex execer, dbtype, script, file string, firstLine int) error {
+	for _, stmt := range splitSQL(script, dbtype) {
+		if _, err := ex.ExecContext(ctx, stmt.Text); err != nil {
+			return &StatementError{File: file, Line: firstLine + stmt.Line - 1, Statement: stmt.Text, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsReadOnly(t *testing.T) {
+	tests := []struct {
+		dbType string
+		script string
+		want   bool
+	}{
+		{"postgres", "SELECT * FROM users", true},
+		{"postgres", "select count(*), coalesce(max(id), 0) from users where id in (1, 2)", true},
+		{"postgres", "WITH recent AS (SELECT * FROM posts) SELECT * FROM recent", true},
+		{"postgres", "SELECT 'DELETE FROM users; setval(1)' AS s -- DROP TABLE users", true},
+		{"postgres", "SELECT x::numeric(10, 2), row_number() OVER (PARTITION BY a ORDER BY b) FROM t", true},
+		{"postgres", "SELECT * FROM t WHERE EXISTS (SELECT 1 FROM u WHERE u.id = t.id)", true},
+		{"mysql", "SHOW TABLES; DESCRIBE users", true},
+		{"postgres", "SELECT * INTO backup FROM users", false},
+		{"mysql", "SELECT * FROM users INTO OUTFILE '/tmp/users.csv'", false},
+		{"postgres", "SELECT setval('users_id_seq', 1)", false},
+		{"postgres", "SELECT pg_catalog.setval('users_id_seq', 1)", false},
+		{"postgres", "SELECT pg_terminate_backend(42)", false},
+		{"postgres", "SELECT dblink('dbname=prod', 'DELETE FROM users')", false},
+		{"postgres", "SELECT set_config('search_path', 'x', false)", false},
+		{"postgres", "SELECT pg_advisory_lock(1)", false},
+		{"postgres", "SELECT lo_from_bytea(0, 'x')", false},
+		{"postgres", "SELECT my_cleanup()", false},
+		{"postgres", "SELECT public.count(*)", false},
+		{"postgres", `SELECT "setval"('s', 1)`, false},
+		{"postgres", "SELECT 1; DELETE FROM users", false},
+		{"postgres", "(SELECT 1)", false},
+		{"mysql", "SELECT /*! 1; DELETE FROM users */ 1", false},
+		{"sqlite", "PRAGMA foreign_keys = ON", false},
+	}
+	for _, tt := range tests {
+		if got := IsReadOnly(tt.script, tt.dbType); got != tt.want {
+			t.Errorf("IsReadOnly(%q, %s) = %v, want %v", tt.script, tt.dbType, got, tt.want)
+		}
+	}
+}
+
+func TestSplitSQL(t *testing.T) {
+	tests := []struct {
+		name, dbType, script string
+		want                 []sqlStatement
+	}{
+		{"lines", "sqlite", "\n\nSELECT 1;\n\n  SELECT 2;\nSELECT 3", []sqlStatement{{"SELECT 1", 3}, {"SELECT 2", 5}, {"SELECT 3", 6}}},
+		{"comments", "postgres", "-- a; b\nSELECT 1; /* c; d */\nSELECT '--;' -- e;\n;", []sqlStatement{{"SELECT 1", 2}, {"SELECT '--;' -- e;", 3}}},
+		{"quotes", "sqlite", `SELECT 'it''s; here', "a;b";` + "\nSELECT 2;", []sqlStatement{{`SELECT 'it''s; here', "a;b"`, 1}, {"SELECT 2", 2}}},
+		{"dollar quotes", "postgres", "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;\nSELECT 2;", []sqlStatement{{"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql", 1}, {"SELECT 2", 2}}},
+		{"tagged dollar quotes", "postgres", "DO $body$ BEGIN RAISE NOTICE '$$;'; END $body$;\nSELECT $1;", []sqlStatement{{"DO $body$ BEGIN RAISE NOTICE '$$;'; END $body$", 1}, {"SELECT $1", 2}}},
+		{"escape strings", "postgres", `INSERT INTO t VALUES (E'it\'s; here', e'\\');` + "\nSELECT 2;", []sqlStatement{{`INSERT INTO t VALUES (E'it\'s; here', e'\\')`, 1}, {"SELECT 2", 2}}},
+		{"standard strings keep backslashes", "postgres", `SELECT 'C:\';` + "\nSELECT 2;", []sqlStatement{{`SELECT 'C:\'`, 1}, {"SELECT 2", 2}}},
+		{"sqlite trigger", "sqlite", "CREATE TRIGGER t AFTER INSERT ON a BEGIN\n  UPDATE b SET n = n + 1;\n  DELETE FROM c;\nEND;\nSELECT 1;", []sqlStatement{{"CREATE TRIGGER t AFTER INSERT ON a BEGIN\n  UPDATE b SET n = n + 1;\n  DELETE FROM c;\nEND", 1}, {"SELECT 1", 5}}},
+		{"begin atomic", "postgres", "CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT 2; END;\nSELECT 3;", []sqlStatement{{"CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT 2; END", 1}, {"SELECT 3", 2}}},
+		{"transaction", "sqlite", "BEGIN;\nINSERT INTO a VALUES (1);\nCOMMIT;", []sqlStatement{{"BEGIN", 1}, {"INSERT INTO a VALUES (1)", 2}, {"COMMIT", 3}}},
+		{"mysql procedure", "mysql", "CREATE PROCEDURE p() BEGIN IF x THEN SELECT 1; END IF; CASE y WHEN 1 THEN SELECT 2; END CASE; END;\nSELECT 3;", []sqlStatement{{"CREATE PROCEDURE p() BEGIN IF x THEN SELECT 1; END IF; CASE y WHEN 1 THEN SELECT 2; END CASE; END", 1}, {"SELECT 3", 2}}},
+		{"mysql hash comments", "mysql", "SELECT 1; # drop; this\nSELECT 2;", []sqlStatement{{"SELECT 1", 1}, {"SELECT 2", 2}}},
+		{"mysql executable comments", "mysql", "/*!40101 SET NAMES utf8; */;\nSELECT 1;", []sqlStatement{{"/*!40101 SET NAMES utf8; */", 1}, {"SELECT 1", 2}}},
+		{"mysql backslash escapes", "mysql", `INSERT INTO t VALUES ('a\';b', "c\";d");`, []sqlStatement{{`INSERT INTO t VALUES ('a\';b', "c\";d")`, 1}}},
+		{"hash is not a comment outside mysql", "postgres", "SELECT '{1}'::jsonb #> '{a}';", []sqlStatement{{"SELECT '{1}'::jsonb #> '{a}'", 1}}},
+	}
+	for _, tt := range tests {
+		if got := splitSQL(tt.script, tt.dbType); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: splitSQL = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SquashDirective starts a migration that replaces older ones, followed by the files it replaces,
+// e.g. "-- schema squash: 1_users.sql, 2_posts.sql".
+const SquashDirective = "-- schema squash:"
+
+// squashedFiles returns the files listed by the squash directive of content, if it has one.
+func squashedFiles(content []byte) []string {
+	for _, line := range strings.Split(string(content), "\n") {
+		list, ok := strings.CutPrefix(strings.TrimSpace(line), SquashDirective)
+		if !ok {
+			continue
+		}
+		var files []string
+		for _, file := range strings.Split(list, ",") {
+			if file = strings.TrimSpace(file); file != "" {
+				files = append(files, file)
+			}
+		}
+		return files
+	}
+	return nil
+}
+
+// SnapshotSQL returns the statements that create every table of db with its indexes, referenced
+// tables first, and the statements that drop them again.
+func SnapshotSQL(db *Database, dbType string) (up, down string) {
+	tables := make(map[string]Table)
+	var names []string
+	for _, t := range db.Tables {
+		if IsInternalTable(t.Name) {
+			continue
+		}
+		tables[t.Name] = t
+		names = append(names, t.Name)
+	}
+	sort.Strings(names)
+
+	var ordered []Table
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		t, ok := tables[name]
+		if !ok || visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, c := range t.Constraints {
+			if c.Kind == ForeignKey {
+				visit(c.ReferenceTable)
+			}
+		}
+		ordered = append(ordered, t)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+
+	var upStmts, downStmts []string
+	if dbType == "postgres" {
+		qualified := names
+		for _, e := range db.Enums {
+			qualified = append(qualified, e.Name)
+		}
+		upStmts = append(upStmts, createSchemaStatements(qualified)...)
+		for _, e := range db.Enums {
+			vals := make([]string, len(e.Values))
+			for i, v := range e.Values {
+				vals[i] = fmt.Sprintf("'%s'", v)
+			}
+			upStmts = append(upStmts, fmt.Sprintf("CREATE TYPE %s AS ENUM (%s);", e.Name, strings.Join(vals, ", ")))
+		}
+	}
+	for _, t := range ordered {
+		upStmts = append(upStmts, generateCreateTableSQL(t, dbType))
+		for _, idx := range t.Indexes {
+			uniq := ""
+			if idx.IsUnique {
+				uniq = "UNIQUE "
+			}
+			upStmts = append(upStmts, fmt.Sprintf("CREATE %sINDEX %s ON %s (%s);", uniq, idx.Name, t.Name, strings.Join(idx.Columns, ", ")))
+		}
+	}
+
+	for i := len(ordered) - 1; i >= 0; i-- {
+		downStmts = append(downStmts, fmt.Sprintf("DROP TABLE %s;", ordered[i].Name))
+	}
+	if dbType == "postgres" {
+		for _, e := range db.Enums {
+			downStmts = append(downStmts, fmt.Sprintf("DROP TYPE %s;", e.Name))
+		}
+	}
+	return strings.Join(upStmts, "\n\n"), strings.Join(downStmts, "\n")
+}
+
+// Squash runs the migration sections of files against scratch, an empty database of the same
+// type, and returns a migration that recreates the resulting schema in one file. Its squash
+// directive lists files, so ReconcileSquashes can recognise databases that already applied them.
+// Files with statements the snapshot can't carry over, such as views, triggers, functions and
+// seed data, are refused with an *UnsquashableError before anything runs.
+func (m *Migrator) Squash(ctx context.Context, scratch *sql.DB, files []string) (string, error) {
+	if err := m.ensureEmpty(ctx, scratch); err != nil {
+		return "", err
+	}
+
+	sections := make([]string, len(files))
+	var lost []UnsquashableStatement
+	for i, file := range files {
+		content, err := m.readFile(file)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", file, err)
+		}
+		section, _ := migrationSection(content, false)
+		if sections[i], err = expandPlaceholders(section, file, m.DBType, m.Vars); err != nil {
+			return "", err
+		}
+		for _, stmt := range splitSQL(sections[i], m.DBType) {
+			if !isSnapshotStatement(stmt.Text, m.DBType) {
+				lost = append(lost, UnsquashableStatement{File: file, Line: stmt.Line, Statement: stmt.Text})
+			}
+		}
+	}
+	if len(lost) > 0 {
+		return "", &UnsquashableError{Statements: lost}
+	}
+
+	for i, file := range files {
+		if err := execStatements(ctx, scratch, m.DBType, sections[i], file, 1); err != nil {
+			return "", err
+		}
+	}
+
+	snapshot, err := m.config.InspectSchema(ctx, scratch, m.DBType)
+	if err != nil {
+		return "", fmt.Errorf("inspecting squashed schema: %w", err)
+	}
+	up, down := SnapshotSQL(snapshot, m.DBType)
+	return fmt.Sprintf("%s %s\n%s\n\n-- schema rollback\n%s\n", SquashDirective, strings.Join(files, ", "), up, down), nil
+}
+
+// isSnapshotStatement reports whether stmt only changes what SnapshotSQL recreates: tables,
+// indexes, enums and schemas. CREATE TABLE ... AS SELECT copies rows, so it doesn't count.
+func isSnapshotStatement(stmt, dbtype string) bool {
+	var words []string
+	scanSQL(stmt, dbtype, func(kind tokenKind, from, to int) {
+		if kind == tokenWord {
+			words = append(words, strings.ToUpper(stmt[from:to]))
+		}
+	})
+	if len(words) < 2 {
+		return false
+	}
+	switch words[0] {
+	case "ALTER":
+		return words[1] == "TABLE" || words[1] == "TYPE"
+	case "DROP":
+		return words[1] == "TABLE" || words[1] == "INDEX" || words[1] == "TYPE" || words[1] == "SCHEMA"
+	case "CREATE":
+		kind := words[1]
+		if (kind == "UNIQUE" || kind == "TEMP" || kind == "TEMPORARY") && len(words) > 2 {
+			kind = words[2]
+		}
+		switch kind {
+		case "TABLE":
+			return !slices.Contains(words, "SELECT")
+		case "INDEX", "SCHEMA":
+			return true
+		case "TYPE":
+			return slices.Contains(words, "ENUM")
+		}
+	}
+	return false
+}
+
+// ReconcileSquashes brings _schema_migrations up to date with squash migrations. A squash whose
+// files were all applied is recorded as applied without running it, and the rows of the files
+// it replaced are removed. It returns the squash migrations it recorded.
+func (m *Migrator) ReconcileSquashes(ctx context.Context) ([]string, error) {
+	entries, err := fs.ReadDir(m.Migrations, ".")
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations directory: %w", err)
+	}
+
+	var recorded []string
+	for _, entry := range entries {
+		file := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(file, ".sql") {
+			continue
+		}
+		content, err := m.readFile(file)
+		if err != nil {
+			return recorded, fmt.Errorf("reading %s: %w", file, err)
+		}
+		replaced := squashedFiles(content)
+		if len(replaced) == 0 {
+			continue
+		}
+
+		status, err := m.migrationStatus(ctx, append([]string{file}, replaced...))
+		if err != nil {
+			return recorded, err
+		}
+		if status[file] {
+			continue
+		}
+		var applied, tracked []string
+		for _, old := range replaced {
+			migrated, ok := status[old]
+			if ok {
+				tracked = append(tracked, old)
+			}
+			if migrated {
+				applied = append(applied, old)
+			}
+		}
+		if len(tracked) == 0 {
+			continue
+		}
+		if len(applied) > 0 && len(applied) < len(replaced) {
+			return recorded, fmt.Errorf("%s replaces migrations that are only partly applied here (%d of %d), apply them with the release before the squash first", file, len(applied), len(replaced))
+		}
+
+		tx, err := m.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return recorded, fmt.Errorf("starting transaction: %w", err)
+		}
+		var firstID int64
+		for _, old := range tracked {
+			var id int64
+			if err := tx.QueryRowContext(ctx, m.dialect.SelectID, old).Scan(&id); err != nil {
+				tx.Rollback()
+				return recorded, fmt.Errorf("reading the id of %s in %s: %w", old, m.table, err)
+			}
+			if firstID == 0 || id < firstID {
+				firstID = id
+			}
+			if _, err := tx.ExecContext(ctx, m.dialect.Delete, old); err != nil {
+				tx.Rollback()
+				return recorded, fmt.Errorf("removing %s from %s: %w", old, m.table, err)
+			}
+		}
+		if len(applied) > 0 {
+			if _, ok := status[file]; !ok {
+				if _, err := tx.ExecContext(ctx, m.dialect.Insert, file, false); err != nil {
+					tx.Rollback()
+					return recorded, fmt.Errorf("adding %s to %s: %w", file, m.table, err)
+				}
+			}
+			// The squash takes the place of the first file it replaces, so rollbacks, which go
+			// newest id first, still reach the migrations applied after it before the squash.
+			if _, err := tx.ExecContext(ctx, m.dialect.UpdateID, firstID, file); err != nil {
+				tx.Rollback()
+				return recorded, fmt.Errorf("moving %s in %s: %w", file, m.table, err)
+			}
+			if _, err := tx.ExecContext(ctx, m.dialect.MarkApplied, checksum(content), 0, m.Version, m.Actor, false, file); err != nil {
+				tx.Rollback()
+				return recorded, fmt.Errorf("marking %s as applied: %w", file, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return recorded, fmt.Errorf("committing transaction: %w", err)
+		}
+		if len(applied) > 0 {
+			m.logf("Recorded %s as applied, it replaces %s", file, strings.Join(replaced, ", "))
+			recorded = append(recorded, file)
+		}
+	}
+	return recorded, nil
+}
+
+// migrationStatus returns whether each of files that has a row in _schema_migrations is applied.
+func (m *Migrator) migrationStatus(ctx context.Context, files []string) (map[string]bool, error) {
+	status := make(map[string]bool)
+	for _, file := range files {
+		var migrated bool
+		err := m.DB.QueryRowContext(ctx, m.dialect.SelectStatus, file).Scan(&migrated)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("querying migration status of %s: %w", file, err)
+		}
+		status[file] = migrated
+	}
+	return status, nil
+}
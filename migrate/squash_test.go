@@ -0,0 +1,70 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSquashRefusesStatementsTheSnapshotDrops(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t, fstest.MapFS{
+		"1_users.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\nCREATE INDEX users_name ON users (name);\n")},
+		"2_posts.sql": {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id));\nALTER TABLE posts ADD COLUMN title TEXT;\n")},
+		"3_seed.sql":  {Data: []byte("CREATE VIEW named AS SELECT name FROM users;\nINSERT INTO users (name) VALUES ('admin');\n")},
+	})
+
+	scratch, err := Open("sqlite", filepath.Join(t.TempDir(), "scratch.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scratch.Close()
+
+	_, err = m.Squash(ctx, scratch, []string{"1_users.sql", "2_posts.sql", "3_seed.sql"})
+	var squashErr *UnsquashableError
+	if !errors.As(err, &squashErr) {
+		t.Fatalf("Squash error = %v, want an *UnsquashableError", err)
+	}
+	if len(squashErr.Statements) != 2 || squashErr.Statements[0].Line != 1 || squashErr.Statements[1].Line != 2 {
+		t.Errorf("unsquashable statements = %+v, want the view and the insert of 3_seed.sql", squashErr.Statements)
+	}
+
+	content, err := m.Squash(ctx, scratch, []string{"1_users.sql", "2_posts.sql"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"-- schema squash: 1_users.sql, 2_posts.sql", "CREATE TABLE users", "CREATE TABLE posts", "users_name"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("squash is missing %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestIsSnapshotStatement(t *testing.T) {
+	tests := []struct {
+		stmt string
+		want bool
+	}{
+		{"CREATE TABLE users (id INT)", true},
+		{"create table if not exists users (id int)", true},
+		{"CREATE UNIQUE INDEX users_email ON users (email)", true},
+		{"ALTER TABLE users ADD COLUMN email TEXT", true},
+		{"DROP INDEX users_email", true},
+		{"CREATE TYPE mood AS ENUM ('happy', 'sad')", true},
+		{"CREATE SCHEMA billing", true},
+		{"CREATE TYPE point AS (x INT, y INT)", false},
+		{"CREATE TABLE archive AS SELECT * FROM users", false},
+		{"CREATE VIEW named AS SELECT name FROM users", false},
+		{"CREATE TRIGGER t AFTER INSERT ON users BEGIN SELECT 1; END", false},
+		{"INSERT INTO users (name) VALUES ('admin')", false},
+		{"UPDATE users SET name = 'x'", false},
+	}
+	for _, tt := range tests {
+		if got := isSnapshotStatement(tt.stmt, "postgres"); got != tt.want {
+			t.Errorf("isSnapshotStatement(%q) = %v, want %v", tt.stmt, got, tt.want)
+		}
+	}
+}
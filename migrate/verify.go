@@ -0,0 +1,99 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ensureEmpty returns an error if scratch has any tables of its own.
+func (m *Migrator) ensureEmpty(ctx context.Context, scratch *sql.DB) error {
+	existing, err := m.config.InspectSchema(ctx, scratch, m.DBType)
+	if err != nil {
+		return fmt.Errorf("inspecting scratch database: %w", err)
+	}
+	for _, t := range existing.Tables {
+		if !m.config.IsInternalTable(t.Name) {
+			return fmt.Errorf("scratch database is not empty, it has table %s", t.Name)
+		}
+	}
+	return nil
+}
+
+// CloneSchema recreates the tables, indexes and enums of the database in scratch, an empty
+// database of the same type. Data, views and functions are not copied.
+func (m *Migrator) CloneSchema(ctx context.Context, scratch *sql.DB) error {
+	if err := m.ensureEmpty(ctx, scratch); err != nil {
+		return err
+	}
+	current, err := m.config.InspectSchema(ctx, m.DB, m.DBType)
+	if err != nil {
+		return fmt.Errorf("inspecting database schema: %w", err)
+	}
+	up, _ := SnapshotSQL(current, m.DBType)
+	return execStatements(ctx, scratch, m.DBType, up, "schema snapshot", 1)
+}
+
+// VerifyRollback checks the rollback sections of files on scratch, a database with the schema
+// the files start from. Each file is applied, rolled back and applied again, and the schema is
+// compared after every step. It returns a *RollbackMismatchError for the first file whose
+// rollback doesn't restore the schema it started from.
+func (m *Migrator) VerifyRollback(ctx context.Context, scratch *sql.DB, files []string) error {
+	for _, file := range files {
+		content, err := m.readFile(file)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", file, err)
+		}
+		rollbackSQL, err := migrationSection(content, true)
+		if err != nil {
+			return fmt.Errorf("%w in %s", err, file)
+		}
+		if rollbackSQL, err = expandPlaceholders(rollbackSQL, file, m.DBType, m.Vars); err != nil {
+			return err
+		}
+		migrationSQL, _ := migrationSection(content, false)
+		if migrationSQL, err = expandPlaceholders(migrationSQL, file, m.DBType, m.Vars); err != nil {
+			return err
+		}
+
+		before, err := m.config.InspectSchema(ctx, scratch, m.DBType)
+		if err != nil {
+			return fmt.Errorf("inspecting scratch database: %w", err)
+		}
+		if err := execStatements(ctx, scratch, m.DBType, migrationSQL, file, 1); err != nil {
+			return err
+		}
+		applied, err := m.config.InspectSchema(ctx, scratch, m.DBType)
+		if err != nil {
+			return fmt.Errorf("inspecting scratch database: %w", err)
+		}
+
+		if err := execStatements(ctx, scratch, m.DBType, rollbackSQL, file, rollbackLine(content)); err != nil {
+			return err
+		}
+		if err := m.compareSchemas(ctx, scratch, before, file, false); err != nil {
+			return err
+		}
+
+		if err := execStatements(ctx, scratch, m.DBType, migrationSQL, file, 1); err != nil {
+			return err
+		}
+		if err := m.compareSchemas(ctx, scratch, applied, file, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareSchemas returns a *RollbackMismatchError if the schema of scratch isn't expected.
+func (m *Migrator) compareSchemas(ctx context.Context, scratch *sql.DB, expected *Database, file string, reapplied bool) error {
+	found, err := m.config.InspectSchema(ctx, scratch, m.DBType)
+	if err != nil {
+		return fmt.Errorf("inspecting scratch database: %w", err)
+	}
+	diff := DiffSchemas(found, expected)
+	if diff.IsEmpty() {
+		return nil
+	}
+	return &RollbackMismatchError{File: file, Reapplied: reapplied, Diff: GenerateMigrationSQL(diff, m.DBType)}
+}
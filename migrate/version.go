@@ -0,0 +1,94 @@
+package migrate
+
+import (
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampLayout is the version prefix of migrations created with timestamp versioning,
+// e.g. 20261017T120000_add_users.sql.
+const TimestampLayout = "20060102T150405"
+
+// versionKind is the kind of version prefix a migration file has, in sort order.
+type versionKind int
+
+const (
+	kindNumbered versionKind = iota
+	kindTimestamp
+	// kindUnversioned is a file name without a numeric or timestamp prefix.
+	kindUnversioned
+)
+
+// migrationVersion is the parsed version prefix of a migration file name.
+type migrationVersion struct {
+	Kind   versionKind
+	Number int
+	Stamp  string
+}
+
+// parseMigrationVersion reads the prefix before the first underscore of a migration file name.
+func parseMigrationVersion(file string) migrationVersion {
+	prefix, _, _ := strings.Cut(file, "_")
+	if n, err := strconv.Atoi(prefix); err == nil {
+		return migrationVersion{Kind: kindNumbered, Number: n}
+	}
+	if _, err := time.Parse(TimestampLayout, prefix); err == nil {
+		return migrationVersion{Kind: kindTimestamp, Stamp: prefix}
+	}
+	return migrationVersion{Kind: kindUnversioned}
+}
+
+// CompareMigrations orders migration file names by version, then by name. Numeric versions sort
+// before timestamp versions, and files with neither sort last.
+func CompareMigrations(a, b string) int {
+	va, vb := parseMigrationVersion(a), parseMigrationVersion(b)
+	switch {
+	case va.Kind != vb.Kind:
+		return int(va.Kind - vb.Kind)
+	case va.Number != vb.Number:
+		if va.Number < vb.Number {
+			return -1
+		}
+		return 1
+	case va.Stamp != vb.Stamp:
+		return strings.Compare(va.Stamp, vb.Stamp)
+	}
+	return strings.Compare(a, b)
+}
+
+// SortMigrations sorts migration file names in version order.
+func SortMigrations(files []string) {
+	sort.SliceStable(files, func(i, j int) bool { return CompareMigrations(files[i], files[j]) < 0 })
+}
+
+// NextMigrationPrefix returns the version prefix for a new migration in a directory: the current
+// UTC time with timestamp versioning or once the directory has a timestamped file, since a
+// number would sort before it, otherwise one more than the highest numeric prefix. A timestamp
+// is moved past the latest one in the directory, so files created within a second stay apart.
+func NextMigrationPrefix(entries []fs.DirEntry, timestamp bool) string {
+	maxPrefix, maxStamp := -1, ""
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		switch v := parseMigrationVersion(entry.Name()); v.Kind {
+		case kindTimestamp:
+			timestamp = true
+			maxStamp = max(maxStamp, v.Stamp)
+		case kindNumbered:
+			maxPrefix = max(maxPrefix, v.Number)
+		}
+	}
+	if !timestamp {
+		return strconv.Itoa(maxPrefix + 1)
+	}
+	now := time.Now().UTC().Format(TimestampLayout)
+	if now <= maxStamp {
+		latest, _ := time.Parse(TimestampLayout, maxStamp)
+		return latest.Add(time.Second).Format(TimestampLayout)
+	}
+	return now
+}
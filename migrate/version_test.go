@@ -0,0 +1,80 @@
+package migrate
+
+import (
+	"io/fs"
+	"reflect"
+	"regexp"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCompareMigrations(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"9_a.sql", "10_a.sql", -1},
+		{"10_a.sql", "9_a.sql", 1},
+		{"2_a.sql", "2_b.sql", -1},
+		{"2_a.sql", "2_a.sql", 0},
+		{"120_a.sql", "20261017T120000_a.sql", -1},
+		{"20261017T120000_a.sql", "20261017T115959_a.sql", 1},
+		{"20261017T120000_a.sql", "notes.sql", -1},
+		{"notes.sql", "5_a.sql", 1},
+	}
+	for _, tt := range tests {
+		if got := CompareMigrations(tt.a, tt.b); sign(got) != tt.want {
+			t.Errorf("CompareMigrations(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestSortMigrations(t *testing.T) {
+	files := []string{"20261017T120000_c.sql", "10_b.sql", "notes.sql", "9_a.sql", "0_init.sql", "20261001T000000_x.sql", "11_c.sql"}
+	SortMigrations(files)
+	want := []string{"0_init.sql", "9_a.sql", "10_b.sql", "11_c.sql", "20261001T000000_x.sql", "20261017T120000_c.sql", "notes.sql"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("SortMigrations = %v, want %v", files, want)
+	}
+}
+
+func TestNextMigrationPrefix(t *testing.T) {
+	timestamp := regexp.MustCompile(`^\d{8}T\d{6}$`)
+	entries := func(files ...string) []fs.DirEntry {
+		dir := fstest.MapFS{}
+		for _, f := range files {
+			dir[f] = &fstest.MapFile{}
+		}
+		list, err := fs.ReadDir(dir, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	if got := NextMigrationPrefix(entries(), false); got != "0" {
+		t.Errorf("empty directory: got %s, want 0", got)
+	}
+	if got := NextMigrationPrefix(entries("0_init.sql", "9_a.sql", "10_b.sql", "notes.txt"), false); got != "11" {
+		t.Errorf("numbered directory: got %s, want 11", got)
+	}
+	if got := NextMigrationPrefix(entries("0_init.sql", "1_a.sql"), true); !timestamp.MatchString(got) {
+		t.Errorf("timestamp versioning: got %s, want a timestamp", got)
+	}
+	if got := NextMigrationPrefix(entries("0_init.sql", "1_a.sql", "20261017T120000_b.sql"), false); !timestamp.MatchString(got) {
+		t.Errorf("after a timestamped file: got %s, want a timestamp", got)
+	}
+	if got := NextMigrationPrefix(entries("30001231T235959_future.sql"), true); got != "30010101T000000" {
+		t.Errorf("after a later timestamp: got %s, want 30010101T000000", got)
+	}
+}
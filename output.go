@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gigagrug/schema/migrate"
+)
+
+// Exit codes, shared by the text and JSON output modes.
+const (
+	exitFailure = 1
+	// exitUsage is for an unknown subcommand, the code the flag package exits with on invalid flags.
+	exitUsage = 2
+	// exitPending means there are migrations left to apply.
+	exitPending = 3
+	// exitDrift means the database no longer matches the migration files or db.schema.
+	exitDrift = 4
+)
+
+var (
+	// jsonOutput is set by the global --output json flag. Results are written to jsonWriter as
+	// one JSON object, and the human readable messages go to stderr instead of stdout.
+	jsonOutput bool
+	jsonWriter io.Writer = os.Stdout
+	jsonResult           = make(map[string]any)
+)
+
+// parseOutputFlag removes the global --output flag from args and switches to JSON output when it
+// is json.
+func parseOutputFlag(args []string) ([]string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "output" {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			value = args[i]
+		}
+		switch value {
+		case "json":
+			jsonOutput = true
+		case "text":
+			jsonOutput = false
+		default:
+			return nil, fmt.Errorf("unknown output '%s', expected \"text\" or \"json\"", value)
+		}
+	}
+
+	if jsonOutput {
+		jsonWriter = os.Stdout
+		os.Stdout = os.Stderr
+	}
+	return rest, nil
+}
+
+// setResult records a field of the command's JSON result. It does nothing in text mode.
+func setResult(key string, value any) {
+	if jsonOutput {
+		jsonResult[key] = value
+	}
+}
+
+// writeResult writes the JSON result of the command, if JSON output is on.
+func writeResult(command string, code int, errObj map[string]any) {
+	if !jsonOutput {
+		return
+	}
+	jsonResult["command"] = command
+	jsonResult["ok"] = errObj == nil
+	jsonResult["exit_code"] = code
+	if errObj != nil {
+		jsonResult["error"] = errObj
+	}
+	enc := json.NewEncoder(jsonWriter)
+	enc.SetIndent("", "  ")
+	enc.Encode(jsonResult)
+}
+
+// exit ends a successful command with code, writing its JSON result first.
+func exit(code int) {
+	writeResult(currentCommand(), code, nil)
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	os.Exit(code)
+}
+
+func currentCommand() string {
+	if len(os.Args) > 1 {
+		return os.Args[1]
+	}
+	return ""
+}
+
+// errorObject describes err for JSON output with a stable code and the details of errors from the
+// migrate package.
+func errorObject(message string, err error) map[string]any {
+	obj := map[string]any{"code": "error", "message": message}
+	if err == nil {
+		return obj
+	}
+
+	var checksumErr *migrate.ChecksumError
+	var orderErr *migrate.OutOfOrderError
+	var stmtErr *migrate.StatementError
+	var lockErr *migrate.LockTimeoutError
+	var placeholderErr *migrate.PlaceholderError
+	var rollbackErr *migrate.RollbackMismatchError
+	var movedErr *migrate.TrackingTableMovedError
+	var squashErr *migrate.UnsquashableError
+	switch {
+	case errors.As(err, &checksumErr):
+		obj["code"] = "checksum_mismatch"
+		files := make([]string, len(checksumErr.Mismatches))
+		for i, m := range checksumErr.Mismatches {
+			files[i] = m.File
+		}
+		obj["files"] = files
+	case errors.As(err, &orderErr):
+		obj["code"] = "out_of_order"
+		obj["latest"] = orderErr.Latest
+		obj["files"] = orderErr.Files
+	case errors.As(err, &stmtErr):
+		obj["code"] = "statement_failed"
+		obj["file"] = stmtErr.File
+		obj["line"] = stmtErr.Line
+		obj["statement"] = stmtErr.Statement
+		obj["no_transaction"] = stmtErr.NoTransaction
+	case errors.As(err, &lockErr):
+		obj["code"] = "lock_timeout"
+		obj["holder"] = lockErr.Holder
+	case errors.As(err, &placeholderErr):
+		obj["code"] = "unresolved_placeholder"
+		obj["file"] = placeholderErr.File
+		obj["placeholders"] = placeholderErr.Placeholders
+	case errors.As(err, &rollbackErr):
+		obj["code"] = "rollback_mismatch"
+		obj["file"] = rollbackErr.File
+		obj["diff"] = rollbackErr.Diff
+	case errors.As(err, &movedErr):
+		obj["code"] = "tracking_table_moved"
+		obj["table"] = movedErr.Table
+		obj["existing"] = movedErr.Existing
+	case errors.As(err, &squashErr):
+		obj["code"] = "unsquashable"
+		files := make([]string, 0, len(squashErr.Statements))
+		for _, st := range squashErr.Statements {
+			if !slices.Contains(files, st.File) {
+				files = append(files, st.File)
+			}
+		}
+		obj["files"] = files
+	case errors.Is(err, migrate.ErrNoRollback):
+		obj["code"] = "no_rollback"
+	case errors.Is(err, migrate.ErrNotPending):
+		obj["code"] = "not_pending"
+	case errors.Is(err, migrate.ErrNotApplied):
+		obj["code"] = "not_applied"
+	case errors.Is(err, migrate.ErrUnsupportedDB):
+		obj["code"] = "unsupported_database"
+	case errors.Is(err, errProtected):
+		obj["code"] = "protected_environment"
+	case errors.Is(err, errUnsafeChange):
+		obj["code"] = "unsafe_change"
+	}
+	return obj
+}
+
+// nonNil returns s, or an empty slice when s is nil, so it is written as [] rather than null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// jsonRow copies a scanned row for JSON output, with text columns that the driver returned as
+// bytes written as strings.
+func jsonRow(values []any) []any {
+	row := make([]any, len(values))
+	for i, v := range values {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		row[i] = v
+	}
+	return row
+}